@@ -3,6 +3,7 @@ package pzip
 import (
 	"archive/zip"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -10,8 +11,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/zdz1715/pzip/flate"
+	"golang.org/x/text/encoding"
 )
 
 const (
@@ -23,6 +27,11 @@ type ArchiveOptions struct {
 
 	tempRoot string
 
+	// TempDir is the directory for overflow files of entries that do not fit
+	// in memory. Archive defaults to a temporary directory beside the output,
+	// ArchiveTo defaults to os.TempDir().
+	TempDir string
+
 	NewCompressor flate.NewWriterFunc
 	Files         []string
 	Level         int
@@ -31,6 +40,150 @@ type ArchiveOptions struct {
 	Dereference   bool
 	Recurse       bool
 	After         func(hdr *FileHeader)
+
+	// FS is the filesystem Files are read from, the OS filesystem if nil.
+	FS fs.FS
+	// Entries are archived after Files, their names are matched on slash.
+	Entries []Entry
+	// Password encrypts files with WinZip AES-256 if not empty.
+	Password string
+	// SplitSize splits the archive of Archive into segments of at most SplitSize
+	// bytes, named .z01, .z02, ... and .zip for the last one.
+	SplitSize int64
+	// NameEncoding writes names in a legacy encoding such as GBK for old
+	// consumers, names it can not encode are written in UTF-8.
+	NameEncoding encoding.Encoding
+	// UnicodeExtra writes names in NameEncoding, or CP437 if nil, followed by
+	// the Info-ZIP Unicode Path and Comment extra fields, for readers that
+	// ignore the UTF-8 flag. Characters out of the encoding are replaced.
+	UnicodeExtra bool
+	// ExtraTimes writes the access and creation times in the extended timestamp
+	// extra field, and the NTFS extra field with 100ns precision.
+	ExtraTimes bool
+	// Xattrs writes the extended attributes of files, including POSIX ACLs,
+	// in the extra field 0x7870 of pzip. They are read on Linux only. The
+	// attributes that do not fit in the 65535 bytes of the extra fields are
+	// left out.
+	Xattrs bool
+	// OwnerNames writes the user and group names of the owners of files in
+	// the extra field 0x6e75 of pzip, beside their uid and gid. Extraction
+	// with RestoreOwner prefers the names to the IDs.
+	OwnerNames bool
+	// Dedup compresses the content of identical files once, the others copy
+	// the compressed data. The files are walked before they are archived to
+	// count their sizes, and the files of a size shared with others are hashed
+	// with SHA-256 before they are compressed.
+	Dedup bool
+	// CompressedExts are the extensions of compressed formats, such as ".zst",
+	// in addition to the built-in ones. Files are stored if their content is
+	// of a compressed format, which is detected by the magic numbers of common
+	// formats and CompressedMagics, or of no known format and the extension.
+	CompressedExts   []string
+	CompressedMagics []Magic
+	// TrialSize deflates the first TrialSize bytes of files of no known format
+	// on trial, which are stored if less than a tenth is saved. 0 disables it.
+	TrialSize int
+	// Rules set the compression method and level of the files they match,
+	// the first matching rule applies. Files of no rule are compressed with
+	// Level unless they are small or compressed already.
+	Rules []Rule
+	// BufferSize is the memory buffer of each file being archived, the rest
+	// of its compressed data is written to an overflow file. It defaults to
+	// 2MB, and the buffers are shared with other archives.
+	BufferSize int64
+	// MaxMemory bounds the buffers of files in flight, from being compressed
+	// until they are written, to at most MaxMemory bytes, by waiting to compress
+	// further files. It must be at least twice BufferSize, 0 is unlimited.
+	// Compressors and the data of hard links, kept in memory up to 64MB and
+	// in a temporary file beyond until their other names are archived or the
+	// walk ends, are not included.
+	MaxMemory int64
+	// MaxTempDisk bounds the overflow files of files in flight, by waiting for
+	// the files compressed already to be written. The files being compressed
+	// are not waited for, and may exceed it together. 0 is unlimited.
+	MaxTempDisk int64
+	// DirectWrite writes the data of files into the archive file of Archive
+	// from the compress workers. Files stored are written in parallel, and
+	// files whose compressed data does not fit in BufferSize are compressed
+	// into the end of the archive instead of overflow files copied again, the
+	// next files wait for them. It is not supported by split archives and ArchiveTo.
+	DirectWrite bool
+	// ReadConcurrency reads the files with ReadConcurrency readers instead of
+	// the compress workers, each reads a file sequentially ahead of the worker
+	// compressing it, by up to 1MB which is not included in MaxMemory. Few
+	// readers keep the reads of hard disks sequential. 0 disables it.
+	ReadConcurrency int
+	// InodeOrder walks the entries of each directory in the order of their
+	// inodes instead of their names with Recurse, which is closer to the order
+	// of their data on disk for most filesystems. Files of FS are not ordered.
+	InodeOrder bool
+	// WalkConcurrency lists the directories of Files with WalkConcurrency
+	// walkers and reads the info of their entries, ahead of the walk with
+	// Recurse. Files are archived in the same order, 0 or 1 walks sequentially.
+	WalkConcurrency int
+	// Ordered writes the files in the order of the walk, as with a single
+	// compress worker, instead of as soon as they are compressed. Compressed
+	// files wait for the previous ones, up to 4 per compress worker if MaxMemory
+	// is not set. Identical files of Dedup follow the first one compressed.
+	// It is not supported with DirectWrite.
+	Ordered bool
+	// BatchSize groups up to BatchSize regular files of at most BatchFileSize
+	// bytes, directories and symbolic links into one task, compressed by one
+	// worker and written back to back, which saves the overhead of each file
+	// for trees of many small files. They have buffers of their size instead
+	// of BufferSize, and a batch counts as one file in MaxMemory. 0 or 1
+	// disables it.
+	BatchSize int
+	// BatchFileSize is the largest file batched, 64KB if 0.
+	BatchFileSize int64
+	// Resume writes the archive of Archive to a partial archive and a journal
+	// of its entries in the directory .pzip-<name>.resume beside it, which are
+	// kept if the archive fails. The next archive with Resume truncates the
+	// entries that are not written completely, keeps the entries of files
+	// whose size and modification time are unchanged and archives the rest.
+	// The partial archive is discarded if the options changing its entries,
+	// such as Level, Password, Rules and NameEncoding, are not the same. The
+	// entries of files changed or removed since are left in the archive without
+	// a record in the central directory, they are skipped by Extract but not
+	// by ExtractStream. It is not supported by split archives and DirectWrite.
+	Resume bool
+
+	pool *ObjectPool
+	// smallPool has the objects of batched files.
+	smallPool *ObjectPool
+	stats     ArchiveStats
+	formats   *formatRules
+	// temps are the overflow files of the archive being written.
+	temps *tempFiles
+	// resume is the partial archive of Resume being written.
+	resume *resume
+}
+
+// ArchiveStats are the statistics of the last archive written with the options.
+type ArchiveStats struct {
+	// Duplicates is the number of files whose compressed data is copied from
+	// an identical file or a hard link of them.
+	Duplicates int
+	// SavedBytes is the uncompressed size of the duplicates, which is not compressed.
+	SavedBytes uint64
+	// Entries is the number of entries written, in Elapsed.
+	Entries int
+	// Resumed is the number of entries kept from the partial archive of Resume.
+	Resumed int
+	Elapsed time.Duration
+}
+
+// EntriesPerSecond returns the number of entries written per second.
+func (s ArchiveStats) EntriesPerSecond() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Entries) / s.Elapsed.Seconds()
+}
+
+// Stats returns the statistics of the last archive written with o.
+func (o *ArchiveOptions) Stats() ArchiveStats {
+	return o.stats
 }
 
 func (o *ArchiveOptions) filterFile() {
@@ -48,40 +201,228 @@ func (o *ArchiveOptions) filterFile() {
 
 func (o *ArchiveOptions) Validate() error {
 	o.filterFile()
-	if len(o.Files) == 0 {
+	if len(o.Files) == 0 && len(o.Entries) == 0 {
 		return errors.New("no files to archive")
 	}
+	if o.SplitSize != 0 && o.SplitSize < MinSplitSize {
+		return fmt.Errorf("split size must be at least %d, got %d", MinSplitSize, o.SplitSize)
+	}
 	if o.Concurrency < 1 {
 		return fmt.Errorf("concurrency must be at least 1, got %d", o.Concurrency)
 	}
+	if o.ReadConcurrency < 0 {
+		return fmt.Errorf("read concurrency must not be negative, got %d", o.ReadConcurrency)
+	}
+	if o.BatchSize < 0 || o.BatchFileSize < 0 {
+		return fmt.Errorf("batch sizes must not be negative")
+	}
+	if o.WalkConcurrency < 0 {
+		return fmt.Errorf("walk concurrency must not be negative, got %d", o.WalkConcurrency)
+	}
+	if o.DirectWrite && o.Ordered {
+		return errors.New("direct writes are not supported by ordered archives")
+	}
+	if o.DirectWrite && o.SplitSize > 0 {
+		return errors.New("direct writes are not supported by split archives")
+	}
+	if o.Resume && (o.SplitSize > 0 || o.DirectWrite) {
+		return errors.New("resume is not supported by split archives and direct writes")
+	}
+	if o.TrialSize < 0 {
+		return fmt.Errorf("trial size must not be negative, got %d", o.TrialSize)
+	}
+	if o.BufferSize < 0 || o.MaxMemory < 0 || o.MaxTempDisk < 0 {
+		return fmt.Errorf("buffer size and budgets must not be negative")
+	}
+	if bufSize := o.bufferSize(); o.MaxMemory > 0 && o.MaxMemory < 2*bufSize {
+		return fmt.Errorf("max memory must be at least twice the buffer size %d, got %d", bufSize, o.MaxMemory)
+	}
+	for i := range o.Rules {
+		if err := o.Rules[i].validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
 	return validLevel(o.Level)
 }
 
-func (o *ArchiveOptions) archiveFile(fileAbsPath, file string, fn func(absPath string, obj *Object) error) (error, error) {
-	if o.Recurse {
-		return o.recurseArchiveFile(file, "", fn)
+func (o *ArchiveOptions) prepare() error {
+	if err := o.Validate(); err != nil {
+		return err
 	}
-	info, err := os.Lstat(file)
-	if err != nil {
-		return err, nil
+
+	if o.NewCompressor == nil {
+		o.NewCompressor = func(w io.Writer, level int) (flate.Writer, error) {
+			return flate.NewFastWriter(w, level)
+		}
+	}
+
+	o.formats = newFormatRules(o.CompressedExts, o.CompressedMagics, o.TrialSize)
+
+	o.pool = DefaultObjectPool
+	if o.BufferSize > 0 && o.BufferSize != defaultBufSize {
+		o.pool = NewObjectPoolSize(o.BufferSize)
+	}
+	o.smallPool = nil
+	if o.BatchSize > 1 {
+		o.smallPool = smallPool(o.batchFileSize() + batchSlack)
+	}
+
+	if o.TempDir != "" {
+		absTempDir, err := filepath.Abs(o.TempDir)
+		if err != nil {
+			return err
+		}
+		o.TempDir = absTempDir
+	}
+	return nil
+}
+
+func (o *ArchiveOptions) bufferSize() int64 {
+	if o.BufferSize > 0 {
+		return o.BufferSize
+	}
+	return defaultBufSize
+}
+
+func (o *ArchiveOptions) batchFileSize() int64 {
+	if o.BatchFileSize > 0 {
+		return o.BatchFileSize
+	}
+	return defaultBatchFileSize
+}
+
+// objectPool returns the pool of the object of a file of info, whose buffer
+// is small if it is batched, as are directories and symbolic links.
+func (o *ArchiveOptions) objectPool(info fs.FileInfo) *ObjectPool {
+	if o.smallPool == nil {
+		return o.pool
+	}
+	mode := info.Mode()
+	if mode.IsDir() || IsSymlink(mode) || mode.IsRegular() && info.Size() >= 0 && info.Size() <= o.batchFileSize() {
+		return o.smallPool
+	}
+	return o.pool
+}
+
+// overflowDir returns the directory where overflow files are created.
+func (o *ArchiveOptions) overflowDir() string {
+	if o.TempDir != "" {
+		return o.TempDir
+	}
+	if o.tempRoot != "" {
+		return o.tempRoot
 	}
+	return os.TempDir()
+}
+
+// isTemp reports whether absPath is created by the archiver itself.
+func (o *ArchiveOptions) isTemp(absPath string) bool {
+	if o.tempRoot != "" && (absPath == o.tempRoot || filepath.Dir(absPath) == o.tempRoot) {
+		return true
+	}
+	if o.resume != nil && (absPath == o.resume.dir || filepath.Dir(absPath) == o.resume.dir) {
+		return true
+	}
+	return o.temps.has(absPath)
+}
 
-	obj, err := DefaultObjectPool.New(file, info, o.Level, o.NewCompressor)
+// tempFiles are the overflow files created by an archive, which may be in the
+// files archived.
+type tempFiles struct {
+	mu    sync.Mutex
+	names map[string]struct{}
+}
+
+// create creates an overflow file in dir, and records it if t is not nil.
+func (t *tempFiles) create(dir string) (*os.File, error) {
+	f, err := os.CreateTemp(dir, overflowPrefix)
+	if err != nil || t == nil {
+		return f, err
+	}
+	name, err := filepath.Abs(f.Name())
 	if err != nil {
-		return err, nil
+		name = f.Name()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.names == nil {
+		t.names = make(map[string]struct{})
+	}
+	t.names[name] = struct{}{}
+	return f, nil
+}
+
+// has reports whether absPath is an overflow file created with t.
+func (t *tempFiles) has(absPath string) bool {
+	if t == nil {
+		return false
 	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.names[absPath]
+	return ok
+}
 
-	obj.Root = o.tempRoot
+// walkFunc is called for each file walked, entry is that of the files of FS
+// and of Entries. It returns the error of the walk and that of the submit.
+type walkFunc func(absPath, name string, info fs.FileInfo, entry *Entry) (error, error)
 
-	return nil, fn(fileAbsPath, obj)
+// walk calls fn for the files and entries of o, in order.
+func (o *ArchiveOptions) walk(fn walkFunc) (error, error) {
+	for _, file := range o.Files {
+		fileAbsPath, err := filepath.Abs(file)
+		if err != nil {
+			return err, nil
+		}
+		// stop submit, wait worker error
+		if err, submitErr := o.walkFile(fileAbsPath, file, fn); err != nil || submitErr != nil {
+			return err, submitErr
+		}
+	}
+	for i := range o.Entries {
+		if o.SkipOnSlash(o.Entries[i].Name) {
+			continue
+		}
+		if err, submitErr := fn("", o.Entries[i].Name, o.Entries[i].info(), &o.Entries[i]); err != nil || submitErr != nil {
+			return err, submitErr
+		}
+	}
+	return nil, nil
 }
 
-func (o *ArchiveOptions) recurseArchiveFile(file string, link string, fn func(absPath string, obj *Object) error) (error, error) {
+func (o *ArchiveOptions) walkFile(fileAbsPath, file string, fn walkFunc) (error, error) {
+	if o.FS != nil {
+		return o.walkFSFile(file, fn)
+	}
+	if o.Recurse {
+		return o.walkTree(file, "", fn)
+	}
+	info, err := os.Lstat(file)
+	if err != nil {
+		return err, nil
+	}
+	return fn(fileAbsPath, file, info, nil)
+}
+
+func (o *ArchiveOptions) walkTree(file string, link string, fn walkFunc) (error, error) {
+	walkDir := filepath.WalkDir
+	switch {
+	case o.WalkConcurrency > 1:
+		walkDir = func(root string, fn fs.WalkDirFunc) error {
+			return walkParallel(root, o.WalkConcurrency, o.InodeOrder, fn)
+		}
+	case o.InodeOrder:
+		walkDir = walkByInode
+	}
 	var submitErr error
-	walkErr := filepath.WalkDir(file, func(path string, d fs.DirEntry, err error) error {
-		if err != nil || submitErr != nil {
+	walkErr := walkDir(file, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
 			return err
 		}
+		// stop walking, the workers failed
+		if submitErr != nil {
+			return filepath.SkipAll
+		}
 
 		if path == "." || path == ".." || path == "./" {
 			return nil
@@ -92,7 +433,7 @@ func (o *ArchiveOptions) recurseArchiveFile(file string, link string, fn func(ab
 			return err
 		}
 		// skip temp dir
-		if absPath == o.tempRoot || filepath.Dir(absPath) == o.tempRoot {
+		if o.isTemp(absPath) {
 			return nil
 		}
 
@@ -120,7 +461,7 @@ func (o *ArchiveOptions) recurseArchiveFile(file string, link string, fn func(ab
 				target = filepath.Join(filepath.Dir(path), target)
 			}
 
-			err, submitErr = o.recurseArchiveFile(target, path, fn)
+			err, submitErr = o.walkTree(target, path, fn)
 			if err != nil {
 				return fmt.Errorf("%s -> %s: %w", path, target, err)
 			}
@@ -128,33 +469,87 @@ func (o *ArchiveOptions) recurseArchiveFile(file string, link string, fn func(ab
 			return nil
 		}
 
-		obj, err := DefaultObjectPool.New(pathOverride, info, o.Level, o.NewCompressor)
+		err, submitErr = fn(absPath, pathOverride, info, nil)
+		return err
+	})
+	return walkErr, submitErr
+}
+
+func (o *ArchiveOptions) walkFSFile(file string, fn walkFunc) (error, error) {
+	if !o.Recurse {
+		info, err := fs.Stat(o.FS, file)
+		if err != nil {
+			return err, nil
+		}
+		return fn("", file, info, FileEntry(o.FS, file, info))
+	}
+
+	var submitErr error
+	walkErr := fs.WalkDir(o.FS, file, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// stop walking, the workers failed
+		if submitErr != nil {
+			return fs.SkipAll
+		}
+
+		if path == "." || o.SkipOnSlash(path) {
+			return nil
+		}
+
+		info, err := d.Info()
 		if err != nil {
 			return err
 		}
-		obj.Root = o.tempRoot
-		submitErr = fn(absPath, obj)
 
-		return nil
+		// fs.FS can not read link targets, archive what the link points to,
+		// a link to a directory is archived as an empty directory.
+		if IsSymlink(info.Mode()) {
+			if info, err = fs.Stat(o.FS, path); err != nil {
+				return err
+			}
+		}
+
+		err, submitErr = fn("", path, info, FileEntry(o.FS, path, info))
+		return err
 	})
 	return walkErr, submitErr
 }
 
+// archiveFile calls fn with the object of a file walked.
+func (o *ArchiveOptions) archiveFile(absPath, name string, info fs.FileInfo, entry *Entry, fn func(absPath string, obj *Object) error) (error, error) {
+	if entry != nil {
+		return o.archiveEntry(entry, fn)
+	}
+	obj, err := o.objectPool(info).New(name, info, o.Level, o.NewCompressor)
+	if err != nil {
+		return err, nil
+	}
+	obj.Root = o.overflowDir()
+
+	return nil, fn(absPath, obj)
+}
+
+func (o *ArchiveOptions) archiveEntry(entry *Entry, fn func(absPath string, obj *Object) error) (error, error) {
+	obj, err := o.objectPool(entry.info()).NewEntry(entry, o.Level, o.NewCompressor)
+	if err != nil {
+		return err, nil
+	}
+	obj.Root = o.overflowDir()
+
+	return nil, fn("", obj)
+}
+
 func Archive(ctx context.Context, path string, opts *ArchiveOptions) (err error) {
 	if opts == nil {
 		return fmt.Errorf("archive options must not be nil")
 	}
 
-	if err = opts.Validate(); err != nil {
+	if err = opts.prepare(); err != nil {
 		return
 	}
 
-	if opts.NewCompressor == nil {
-		opts.NewCompressor = func(w io.Writer, level int) (flate.Writer, error) {
-			return flate.NewFastWriter(w, level)
-		}
-	}
-
 	absZipPath, err := filepath.Abs(path)
 	if err != nil {
 		return
@@ -167,6 +562,13 @@ func Archive(ctx context.Context, path string, opts *ArchiveOptions) (err error)
 
 	defer os.RemoveAll(opts.tempRoot)
 
+	if opts.SplitSize > 0 {
+		return opts.archiveSplit(ctx, absZipPath)
+	}
+	if opts.Resume {
+		return opts.archiveResume(ctx, absZipPath)
+	}
+
 	tmpFile, err := os.CreateTemp(opts.tempRoot, filepath.Base(absZipPath))
 	if err != nil {
 		return
@@ -189,26 +591,175 @@ func Archive(ctx context.Context, path string, opts *ArchiveOptions) (err error)
 	}()
 
 	w := NewWriter(tmpFile)
-	// Execute before tmpFile close
+	if opts.DirectWrite {
+		w = NewWriterAt(tmpFile)
+	}
+	err = opts.archive(ctx, w, absZipPath)
+	return
+}
+
+// archiveSplit writes a split archive, whose segments are renamed beside absZipPath
+// once all are written. An archive of a single segment is a regular archive.
+func (o *ArchiveOptions) archiveSplit(ctx context.Context, absZipPath string) (err error) {
+	var segments []*os.File
+	defer func() {
+		for _, f := range segments {
+			_ = f.Close()
+		}
+	}()
+
+	w, err := NewSplitWriter(o.SplitSize, func(disk uint32) (io.Writer, error) {
+		f, err := os.CreateTemp(o.tempRoot, filepath.Base(absZipPath))
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, f)
+		return f, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = o.archive(ctx, w, absZipPath); err != nil {
+		return err
+	}
+
+	if len(segments) == 1 {
+		// 8.5.4 replace the spanning signature with the temporary spanning
+		// marker, the archive is not split
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], spanningMarker)
+		if _, err = segments[0].WriteAt(buf[:], 0); err != nil {
+			return err
+		}
+	}
+
+	base := strings.TrimSuffix(absZipPath, filepath.Ext(absZipPath))
+	for i, f := range segments {
+		if err = f.Close(); err != nil {
+			return err
+		}
+		name := absZipPath
+		if i < len(segments)-1 {
+			name = fmt.Sprintf("%s.z%02d", base, i+1)
+		}
+		if err = os.Rename(f.Name(), name); err != nil {
+			return err
+		}
+	}
+	segments = nil
+	return nil
+}
+
+// ArchiveTo writes the archive to w, such as os.Stdout or a network connection.
+// Overflow files are created in opts.TempDir, or os.TempDir() if it is empty.
+func ArchiveTo(ctx context.Context, w io.Writer, opts *ArchiveOptions) error {
+	if opts == nil {
+		return fmt.Errorf("archive options must not be nil")
+	}
+
+	if err := opts.prepare(); err != nil {
+		return err
+	}
+	if opts.SplitSize > 0 {
+		return errors.New("split archives can only be written to files")
+	}
+	if opts.DirectWrite {
+		return errors.New("direct writes can only be written to files")
+	}
+	if opts.Resume {
+		return errors.New("resumable archives can only be written to files")
+	}
+
+	return opts.archive(ctx, NewWriter(w), "")
+}
+
+// archive writes all files to w, skipping the file at absZipPath.
+func (o *ArchiveOptions) archive(ctx context.Context, w *Writer, absZipPath string) (err error) {
+	// Execute before out close
 	defer func() {
 		if closeErr := w.Close(); closeErr != nil {
 			err = errors.Join(err, fmt.Errorf("header end write: %w", closeErr))
 		}
 	}()
-	// sequential write
-	writeWorker := NewFailFastWorker[Object](func(params *Object) error {
-		defer func() {
-			_ = params.Close()
-			DefaultObjectPool.Put(params)
-		}()
-
-		if writeErr := params.Archive(w); writeErr != nil {
+	// the workers are stopped if the walk fails
+	ctx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+	write := func(obj *Object) error {
+		if writeErr := obj.Archive(w); writeErr != nil {
 			return writeErr
 		}
-		if opts.After != nil {
-			opts.After(params.header)
+		if o.resume != nil {
+			if writeErr := o.resume.record(w, obj); writeErr != nil {
+				return fmt.Errorf("journal: %w", writeErr)
+			}
+		}
+		o.stats.Entries++
+		if o.After != nil {
+			o.After(obj.header)
 		}
 		return nil
+	}
+	release := func(obj *Object) {
+		_ = obj.Close()
+		if obj.budget != nil {
+			obj.budget.release(obj)
+		}
+		obj.pool.Put(obj)
+	}
+	// releaseBatch releases the objects of a batch that is not written
+	releaseBatch := func(obj *Object) {
+		for _, member := range obj.batched() {
+			release(member)
+		}
+	}
+	o.stats = ArchiveStats{}
+	start := time.Now()
+	defer func() {
+		o.stats.Elapsed = time.Since(start)
+	}()
+	o.temps = new(tempFiles)
+	links := &hardLinks{dir: o.overflowDir(), temps: o.temps, stats: &o.stats}
+	if o.Dedup {
+		links.dedup = newDedup()
+	}
+
+	var place func(params *Object) error
+	place = func(params *Object) error {
+		// the head of a batch may request a region while the rest is compressed
+		if params.regionWanted {
+			return params.createRegion(w)
+		}
+		if params.batch != nil {
+			members := params.batch
+			for i, member := range members {
+				member.batch = nil
+				if err := place(member); err != nil {
+					for _, rest := range members[i+1:] {
+						release(rest)
+					}
+					return err
+				}
+			}
+			return nil
+		}
+		if params.hardLink != nil {
+			return links.write(params, write, release)
+		}
+		defer release(params)
+		return write(params)
+	}
+	var order *sequencer
+	if o.Ordered {
+		order = newSequencer()
+	}
+
+	// sequential write
+	writeWorker := NewFailFastWorker[Object](func(params *Object) error {
+		if order != nil {
+			return order.write(params, place)
+		}
+		return place(params)
 	}, sequentialWrites, sequentialWrites)
 
 	// parallel compression
@@ -216,56 +767,198 @@ func Archive(ctx context.Context, path string, opts *ArchiveOptions) (err error)
 		var compressErr error
 		defer func() {
 			if compressErr != nil {
-				// delete overflow file
-				_ = params.Close()
+				// delete overflow files
+				for _, obj := range params.batched() {
+					_ = obj.Close()
+				}
 			}
 		}()
-		if compressErr = params.Compress(); compressErr != nil {
-			return compressErr
+		batch := params.batched()
+		for i, obj := range batch {
+			if i > 0 && obj.compressor == nil && obj.zstdEncoder == nil {
+				obj.takeCompressors(batch[i-1])
+			}
+			if compressErr = obj.Compress(); compressErr != nil {
+				return compressErr
+			}
 		}
 
+		if params.budget != nil {
+			params.budget.compressed(params)
+		}
 		if compressErr = writeWorker.Submit(params); compressErr != nil {
 			return compressErr
 		}
 		return nil
-	}, opts.Concurrency, opts.Concurrency)
+	}, o.Concurrency, o.Concurrency)
+
+	// sequential reads ahead of compression
+	readWorker := NewFailFastWorker[Object](func(params *Object) error {
+		// the object may be released once submitted
+		ahead, open := params.ahead, params.open
+		if err := compressWorker.Submit(params); err != nil {
+			return err
+		}
+		ahead.fill(open, compressWorker.Done())
+		return nil
+	}, o.ReadConcurrency, o.Concurrency)
 
 	compressWorker.Start(ctx)
 	writeWorker.Start(ctx)
+	if o.ReadConcurrency > 0 {
+		readWorker.Start(ctx)
+	}
+
+	// one buffer is of the object being walked
+	var objects int
+	switch {
+	case o.MaxMemory > 0:
+		objects = int(o.MaxMemory/o.bufferSize()) - 1
+	case o.Ordered:
+		// bound the objects held for the previous ones
+		objects = 4 * o.Concurrency
+	}
+	var direct *directWriter
+	if w.at != nil {
+		direct = &directWriter{submit: writeWorker.Submit, done: writeWorker.Done()}
+	}
+
+	var limits *budget
+	if objects > 0 || o.MaxTempDisk > 0 {
+		// stop waiting for objects that are not released when a worker fails
+		stopCtx, stop := context.WithCancel(ctx)
+		defer stop()
+		go func() {
+			select {
+			case <-compressWorker.Done():
+			case <-writeWorker.Done():
+			case <-stopCtx.Done():
+			}
+			stop()
+		}()
+		limits = newBudget(objects, o.MaxTempDisk, stopCtx.Done())
+	}
 
 	var (
-		submitErr   error
-		fileAbsPath string
+		submitErr error
+		seq       uint64
 	)
-	// add File
-	for _, file := range opts.Files {
-		fileAbsPath, err = filepath.Abs(file)
-		if err != nil {
+	var batches *batcher
+	if o.BatchSize > 1 {
+		batches = &batcher{size: o.BatchSize}
+	}
+	// dispatch submits obj, or a batch, to the workers
+	dispatch := func(obj *Object) error {
+		obj.seq = seq
+		seq++
+		if limits != nil {
+			if err := limits.acquire(obj); err != nil {
+				return err
+			}
+		}
+		if o.ReadConcurrency > 0 && obj.batch == nil && obj.readsAhead() {
+			obj.ahead = newReadAhead()
+			return readWorker.Submit(obj)
+		}
+		return compressWorker.Submit(obj)
+	}
+	submit := func(absPtah string, obj *Object) error {
+		if absPtah != "" && absPtah == absZipPath {
+			return nil
+		}
+		// stop walking once canceled, the workers may still accept objects
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		err, submitErr = opts.archiveFile(fileAbsPath, file, func(absPtah string, obj *Object) error {
-			if absPtah == absZipPath {
+		// the entry of the partial archive is kept
+		if o.resume != nil && o.resume.keep(obj) {
+			o.stats.Resumed++
+			release(obj)
+			return nil
+		}
+		obj.ctx = ctx
+		obj.password = o.Password
+		obj.nameEncoding = o.NameEncoding
+		obj.unicodeExtra = o.UnicodeExtra
+		obj.extraTimes = o.ExtraTimes
+		obj.xattrs = o.Xattrs
+		obj.ownerNames = o.OwnerNames
+		obj.formats = o.formats
+		obj.rules = o.Rules
+		obj.direct = direct
+		obj.temps = o.temps
+		links.add(obj)
+		if links.dedup != nil {
+			links.dedup.add(obj)
+		}
+		if batches != nil && obj.pool == o.smallPool {
+			// batched files are written by the writer, even if they grew
+			obj.direct = nil
+			if obj = batches.add(obj); obj == nil {
 				return nil
 			}
-			return compressWorker.Submit(obj)
-		})
-
-		if err != nil {
-			return err
+		} else if batches != nil && o.Ordered {
+			// the batch is before obj
+			if batch := batches.flush(); batch != nil {
+				if err := dispatch(batch); err != nil {
+					return err
+				}
+			}
 		}
-
-		// stop submit, wait worker error
-		if submitErr != nil {
-			break
+		return dispatch(obj)
+	}
+	// add File and Entry
+	archiveFile := func(absPath, name string, info fs.FileInfo, entry *Entry) (error, error) {
+		return o.archiveFile(absPath, name, info, entry, submit)
+	}
+	if links.dedup != nil {
+		err, submitErr = links.dedup.walk(ctx, o, archiveFile)
+	} else {
+		err, submitErr = o.walk(archiveFile)
+	}
+	links.walkDone()
+	if batches != nil {
+		if batch := batches.flush(); batch != nil {
+			if err != nil || submitErr != nil {
+				releaseBatch(batch)
+			} else {
+				submitErr = dispatch(batch)
+			}
 		}
 	}
 
-	if execErr := compressWorker.Wait(); execErr != nil {
-		err = errors.Join(err, fmt.Errorf("compress: %w", execErr))
+	// the workers are waited for and their objects released even if the walk failed
+	walkFailed := err != nil
+	if walkFailed {
+		stopWorkers()
 	}
-
-	if execErr := writeWorker.Wait(); execErr != nil {
-		err = errors.Join(err, fmt.Errorf("write: %w", execErr))
+	joinErr := func(name string, execErr error) {
+		if execErr != nil && !(walkFailed && errors.Is(execErr, context.Canceled)) {
+			err = errors.Join(err, fmt.Errorf("%s: %w", name, execErr))
+		}
+	}
+	if o.ReadConcurrency > 0 {
+		joinErr("read", readWorker.Wait())
+	}
+	joinErr("compress", compressWorker.Wait())
+	joinErr("write", writeWorker.Wait())
+	// idle workers may stop without an error once canceled, but the walk is not complete
+	if err == nil {
+		err = ctx.Err()
+	}
+	// release the objects left by failed workers, deleting their overflow files
+	if o.ReadConcurrency > 0 {
+		readWorker.Drain(releaseBatch)
+	}
+	compressWorker.Drain(releaseBatch)
+	writeWorker.Drain(releaseBatch)
+	if order != nil {
+		order.release(releaseBatch)
+	}
+	links.release(release)
+	// the central directory has the entries kept before the ones written
+	if err == nil && o.resume != nil {
+		err = o.resume.addKept(w)
 	}
 
 	return
@@ -274,6 +967,8 @@ func Archive(ctx context.Context, path string, opts *ArchiveOptions) (err error)
 type ExtractTarget struct {
 	Path    string
 	Symlink string
+	// HardLink is the path Path is a hard link of.
+	HardLink string
 }
 
 func (e *ExtractTarget) String() string {
@@ -283,6 +978,10 @@ func (e *ExtractTarget) String() string {
 		builder.WriteString(" -> ")
 		builder.WriteString(e.Symlink)
 	}
+	if e.HardLink != "" {
+		builder.WriteString(" => ")
+		builder.WriteString(e.HardLink)
+	}
 	return builder.String()
 }
 
@@ -293,46 +992,283 @@ type ExtractOptions struct {
 	Concurrency int
 	Before      func(path string, r *ReadCloser)
 	After       func(f *File, target *ExtractTarget)
+
+	// FS is where files are extracted to, defaults to OSFS.
+	FS WriteFS
+	// Password decrypts WinZip AES and traditional PKWARE encrypted files.
+	Password string
+	// Prompt returns the password if Password is empty, it is called once
+	// with the name of the first encrypted file.
+	Prompt func(name string) (string, error)
+	// NameEncoding decodes names without the UTF-8 flag or the Info-ZIP Unicode
+	// Path extra field. If nil, names that are not valid UTF-8 are decoded with
+	// the detected encoding.
+	NameEncoding encoding.Encoding
+	// RestoreOwner sets the owner of extracted files from the Info-ZIP Unix extra
+	// fields, it usually requires root and FS must be a ChownFS. The archived
+	// user and group names are preferred to the IDs unless NumericOwner is set.
+	RestoreOwner bool
+	NumericOwner bool
+	// OwnerMap maps archived owners before they are restored.
+	OwnerMap *OwnerMap
+	// SkipTimes does not restore the modification and access times, they are
+	// restored if FS is a ChtimesFS otherwise.
+	SkipTimes bool
+	// Xattrs restores the extended attributes written by ArchiveOptions.Xattrs,
+	// FS must be an XattrFS. Attributes of namespaces that are not allowed,
+	// such as trusted.* for other users than root, or not supported are skipped.
+	Xattrs bool
+	// HardLinks creates the files archived as hard links of others as hard
+	// links, instead of copies, FS must be a LinkFS. Files are extracted as
+	// copies if the others are not extracted or the links can not be created.
+	HardLinks bool
+	// ReadConcurrency reads the data of files with ReadConcurrency readers in
+	// the order of their offsets in the archive, each reads a file ahead of the
+	// worker extracting it by up to 1MB, and files are extracted in that order.
+	// Few readers keep the reads of hard disks sequential. 0 disables it.
+	ReadConcurrency int
+
+	// ctx is the context of the running extraction.
+	ctx        context.Context
+	promptOnce *sync.Once
+	promptErr  error
+	owners     *ownerResolver
 }
 
 func (o *ExtractOptions) Validate() error {
 	if o.Concurrency < 1 {
 		return fmt.Errorf("concurrency must be at least 1, got %d", o.Concurrency)
 	}
+	if o.ReadConcurrency < 0 {
+		return fmt.Errorf("read concurrency must not be negative, got %d", o.ReadConcurrency)
+	}
+	return nil
+}
+
+func (o *ExtractOptions) prepare() error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	o.promptOnce = new(sync.Once)
+	o.promptErr = nil
+	o.owners = nil
+	if o.RestoreOwner {
+		if _, ok := o.fs().(ChownFS); !ok {
+			return errors.New("restore owner: the filesystem does not support ownership")
+		}
+		o.owners = newOwnerResolver(o.NumericOwner, o.OwnerMap)
+	}
+	if o.Xattrs {
+		if _, ok := o.fs().(XattrFS); !ok {
+			return errors.New("restore extended attributes: the filesystem does not support them")
+		}
+	}
+	if o.HardLinks {
+		if _, ok := o.fs().(LinkFS); !ok {
+			return errors.New("hard links: the filesystem does not support them")
+		}
+	}
 	return nil
 }
 
+// password returns the password to decrypt file, prompting for it if needed.
+func (o *ExtractOptions) password(file *File) (string, error) {
+	if o.Prompt != nil {
+		o.promptOnce.Do(func() {
+			if o.Password == "" {
+				o.Password, o.promptErr = o.Prompt(file.Name)
+			}
+		})
+	}
+	return o.Password, o.promptErr
+}
+
+// openEncrypted returns the uncompressed content of the encrypted file read from raw.
+func (o *ExtractOptions) openEncrypted(file *File, raw io.Reader) (io.ReadCloser, error) {
+	password, err := o.password(file)
+	if err != nil {
+		return nil, err
+	}
+	return openEncrypted(file, raw, password)
+}
+
+func (o *ExtractOptions) fs() WriteFS {
+	if o.FS == nil {
+		return OSFS{}
+	}
+	return o.FS
+}
+
+// extractOne extracts job.file, whose raw data is read by a reader if job.ahead is not nil.
+func (o *ExtractOptions) extractOne(job *extractJob) (*ExtractTarget, error) {
+	if job.ahead == nil {
+		return o.extractFile(job.file)
+	}
+	// stop the reader if the data is not read
+	defer job.ahead.Close()
+	file := job.file
+	return o.extractEntry(file, func() (io.ReadCloser, error) {
+		if file.Flags&0x1 != 0 {
+			return o.openEncrypted(file, job.ahead)
+		}
+		return openRaw(file, job.ahead)
+	})
+}
+
 func (o *ExtractOptions) extractFile(file *File) (target *ExtractTarget, err error) {
+	return o.extractEntry(file, func() (io.ReadCloser, error) {
+		if file.Flags&0x1 != 0 {
+			raw, err := file.OpenRaw()
+			if err != nil {
+				return nil, err
+			}
+			return o.openEncrypted(file, raw)
+		}
+		if file.Method == zip.Store {
+			srcReFile, err := file.OpenRaw()
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(srcReFile), nil
+		}
+		return file.Open()
+	})
+}
+
+// extractEntry extracts file, open returns its uncompressed content.
+func (o *ExtractOptions) extractEntry(file *File, open func() (io.ReadCloser, error)) (target *ExtractTarget, err error) {
 	target = &ExtractTarget{
-		Path: file.Name,
-	}
-	if o.OutDir != "" {
-		target.Path = filepath.Join(o.OutDir, file.Name)
+		Path: o.targetPath(file),
 	}
 
 	dir := filepath.Dir(target.Path)
-	if err = os.MkdirAll(dir, 0755); err != nil {
+	if err = o.fs().MkdirAll(dir, 0755); err != nil {
 		return target, fmt.Errorf("create directory %q: %w", dir, err)
 	}
 
-	if strings.HasSuffix(filepath.ToSlash(file.Name), "/") {
-		return target, o.writeDir(target.Path, file)
+	switch {
+	case strings.HasSuffix(filepath.ToSlash(file.Name), "/"):
+		err = o.writeDir(target.Path, file)
+	case IsSymlink(file.Mode()):
+		target.Symlink, err = o.writeLink(target.Path, file, open)
+	default:
+		err = o.writeFile(target.Path, file, open)
+	}
+	if err != nil {
+		return target, err
+	}
+	if err = o.restoreOwner(target.Path, file); err != nil {
+		return target, err
+	}
+	if err = o.restoreXattrs(target.Path, file); err != nil {
+		return target, err
 	}
+	// directories are written to after, their times are restored at last
+	if file.Mode().IsRegular() {
+		err = o.restoreTimes(target.Path, file)
+	}
+	return target, err
+}
 
-	if IsSymlink(file.Mode()) {
-		l, err := o.writeLink(target.Path, file)
-		if err != nil {
-			return target, err
+// extractHardLink creates file as a hard link of the extracted file it is a
+// link of, or extracts it as a copy if that one is not in files or the link fails.
+func (o *ExtractOptions) extractHardLink(file *File, files map[string]*File) (*ExtractTarget, error) {
+	name, _ := FileHardLink(file)
+	if first, ok := files[name]; ok {
+		target := &ExtractTarget{Path: o.targetPath(file), HardLink: o.targetPath(first)}
+		if err := o.link(target.HardLink, target.Path); err == nil {
+			return target, nil
 		}
-		target.Symlink = l
-		return target, nil
 	}
+	return o.extractFile(file)
+}
 
-	return target, o.writeFile(target.Path, file)
+// link creates newname as a hard link of oldname, replacing the file at newname.
+func (o *ExtractOptions) link(oldname, newname string) error {
+	dir := filepath.Dir(newname)
+	if err := o.fs().MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create directory %q: %w", dir, err)
+	}
+	if err := o.fs().Remove(newname); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return o.fs().(LinkFS).Link(oldname, newname)
+}
+
+// targetPath returns the path file is extracted to.
+func (o *ExtractOptions) targetPath(file *File) string {
+	if o.OutDir != "" {
+		return filepath.Join(o.OutDir, file.Name)
+	}
+	return file.Name
+}
+
+// restoreTimes sets the modification and access times of the file extracted to
+// outputPath, if FS supports it.
+func (o *ExtractOptions) restoreTimes(outputPath string, file *File) error {
+	cfs, ok := o.fs().(ChtimesFS)
+	if !ok || o.SkipTimes {
+		return nil
+	}
+	mtime, atime := FileTimes(file)
+	if mtime.IsZero() {
+		return nil
+	}
+	if atime.IsZero() {
+		atime = mtime
+	}
+	if err := cfs.Chtimes(outputPath, atime, mtime); err != nil {
+		return fmt.Errorf("set times of %q: %w", outputPath, err)
+	}
+	return nil
 }
 
-func (o *ExtractOptions) writeLink(outputPath string, file *File) (string, error) {
-	srcFile, err := file.Open()
+// restoreOwner sets the owner of the file extracted to outputPath, if RestoreOwner is set.
+func (o *ExtractOptions) restoreOwner(outputPath string, file *File) error {
+	if o.owners == nil {
+		return nil
+	}
+	owner, ok := FileOwner(file)
+	if !ok {
+		return nil
+	}
+	uid, gid, err := o.owners.resolve(owner)
+	if err != nil {
+		return fmt.Errorf("owner of %q: %w", file.Name, err)
+	}
+	if err = o.fs().(ChownFS).Lchown(outputPath, uid, gid); err != nil {
+		return fmt.Errorf("chown %q: %w", outputPath, err)
+	}
+	// chown clears the setuid and setgid bits
+	if mode := file.Mode(); mode&(fs.ModeSetuid|fs.ModeSetgid) != 0 && !IsSymlink(mode) {
+		if err = o.fs().Chmod(outputPath, mode); err != nil {
+			return fmt.Errorf("chmod %q: %w", outputPath, err)
+		}
+	}
+	return nil
+}
+
+// restoreXattrs sets the extended attributes of the file extracted to
+// outputPath, if Xattrs is set.
+func (o *ExtractOptions) restoreXattrs(outputPath string, file *File) error {
+	if !o.Xattrs {
+		return nil
+	}
+	attrs, err := FileXattrs(file)
+	if err != nil {
+		return err
+	}
+	xfs := o.fs().(XattrFS)
+	for _, attr := range attrs {
+		if err = xfs.Lsetxattr(outputPath, attr.Name, attr.Value); err != nil && !skipXattr(err) {
+			return fmt.Errorf("set extended attribute %q of %q: %w", attr.Name, outputPath, err)
+		}
+	}
+	return nil
+}
+
+func (o *ExtractOptions) writeLink(outputPath string, file *File, open func() (io.ReadCloser, error)) (link string, err error) {
+	srcFile, err := open()
 	if err != nil {
 		return "", fmt.Errorf("open file %q: %w", file.Name, err)
 	}
@@ -342,19 +1278,18 @@ func (o *ExtractOptions) writeLink(outputPath string, file *File) (string, error
 		}
 	}()
 
-	buf := make([]byte, file.CompressedSize64)
-	_, err = srcFile.Read(buf)
+	buf, err := io.ReadAll(srcFile)
 	if err != nil {
 		return "", err
 	}
-	link := string(buf)
-	return link, os.Symlink(link, outputPath)
+	link = string(buf)
+	return link, o.fs().Symlink(link, outputPath)
 }
 
 func (o *ExtractOptions) writeDir(outputPath string, file *File) error {
-	err := os.Mkdir(outputPath, file.Mode())
-	if os.IsExist(err) {
-		if err = os.Chmod(outputPath, file.Mode()); err != nil {
+	err := o.fs().Mkdir(outputPath, file.Mode())
+	if errors.Is(err, fs.ErrExist) {
+		if err = o.fs().Chmod(outputPath, file.Mode()); err != nil {
 			return fmt.Errorf("chmod directory %q: %w", outputPath, err)
 		}
 	} else if err != nil {
@@ -364,52 +1299,47 @@ func (o *ExtractOptions) writeDir(outputPath string, file *File) error {
 	return nil
 }
 
-func (o *ExtractOptions) writeFile(outputPath string, file *File) (err error) {
-	outputFile, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY, file.Mode())
+func (o *ExtractOptions) writeFile(outputPath string, file *File, open func() (io.ReadCloser, error)) (err error) {
+	// open the source first, so that a wrong password does not create the file
+	srcFile, err := open()
 	if err != nil {
-		return fmt.Errorf("create file %q: %w", outputPath, err)
+		return fmt.Errorf("open file %q: %w", file.Name, err)
 	}
 
 	defer func() {
-		if cerr := outputFile.Close(); cerr != nil && err == nil {
-			err = fmt.Errorf("close output file %q: %w", outputPath, cerr)
+		if cerr := srcFile.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("close source file %q: %w", file.Name, cerr)
 		}
 	}()
-	var srcFile io.ReadCloser
-	if file.Method == zip.Store {
-		srcReFile, err := file.OpenRaw()
-		if err != nil {
-			return fmt.Errorf("open file %q: %w", file.Name, err)
-		}
-
-		srcFile = io.NopCloser(srcReFile)
-
-	} else {
-		srcFile, err = file.Open()
-	}
 
+	outputFile, err := o.fs().OpenFile(outputPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, file.Mode())
 	if err != nil {
-		return fmt.Errorf("open file %q: %w", file.Name, err)
+		return fmt.Errorf("create file %q: %w", outputPath, err)
 	}
 
 	defer func() {
-		if cerr := srcFile.Close(); cerr != nil && err == nil {
-			err = fmt.Errorf("close source file %q: %w", file.Name, cerr)
+		if cerr := outputFile.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("close output file %q: %w", outputPath, cerr)
 		}
 	}()
 
-	if _, err = io.Copy(outputFile, srcFile); err != nil {
+	if _, err = copyContext(o.context(), outputFile, srcFile); err != nil {
+		// do not leave a corrupt file
+		_ = outputFile.Close()
+		_ = o.fs().Remove(outputPath)
 		return fmt.Errorf("decompress file %q: %w", file.Name, err)
 	}
 
 	return nil
 }
 
+// Extract extracts the archive at path, which may be the last segment of a
+// split archive with the segments .z01, .z02, ... beside it.
 func Extract(ctx context.Context, path string, opts *ExtractOptions) error {
 	if opts == nil {
 		return errors.New("extract options must not be nil")
 	}
-	if err := opts.Validate(); err != nil {
+	if err := opts.prepare(); err != nil {
 		return err
 	}
 
@@ -423,30 +1353,130 @@ func Extract(ctx context.Context, path string, opts *ExtractOptions) error {
 		opts.Before(path, reader)
 	}
 
-	worker := NewFailFastWorker[File](func(params *File) error {
-		t, extractErr := opts.extractFile(params)
+	return opts.extract(ctx, reader.Reader)
+}
+
+// ExtractFrom extracts the archive of size bytes read from r, such as a bytes.Reader
+// or an object in blob storage.
+//
+// opts.Before is called with an empty path.
+func ExtractFrom(ctx context.Context, r io.ReaderAt, size int64, opts *ExtractOptions) error {
+	if opts == nil {
+		return errors.New("extract options must not be nil")
+	}
+	if err := opts.prepare(); err != nil {
+		return err
+	}
+
+	reader, err := NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	if opts.Before != nil {
+		opts.Before("", &ReadCloser{Reader: reader})
+	}
+
+	return opts.extract(ctx, reader)
+}
+
+func (o *ExtractOptions) extract(ctx context.Context, reader *Reader) error {
+	o.ctx = ctx
+	worker := NewFailFastWorker[extractJob](func(params *extractJob) error {
+		t, extractErr := o.extractOne(params)
 		if extractErr != nil {
 			return extractErr
 		}
-		if opts.After != nil {
-			opts.After(params, t)
+		if o.After != nil {
+			o.After(params.file, t)
 		}
 		return nil
-	}, opts.Concurrency, opts.Concurrency)
+	}, o.Concurrency, o.Concurrency)
+
+	// sequential reads ahead of extraction
+	readWorker := NewFailFastWorker[extractJob](func(params *extractJob) error {
+		if err := worker.Submit(params); err != nil {
+			return err
+		}
+		if params.ahead != nil {
+			params.ahead.fill(params.openRaw, worker.Done())
+		}
+		return nil
+	}, o.ReadConcurrency, o.Concurrency)
 
 	worker.Start(ctx)
+	submit := worker.Submit
+	if o.ReadConcurrency > 0 {
+		readWorker.Start(ctx)
+		submit = readWorker.Submit
+	}
 
+	DecodeNames(reader.File, o.NameEncoding)
+	var (
+		dirs  []*File
+		links []*File
+		// regular files by name, that hard links are created of
+		files = make(map[string]*File)
+		jobs  []*extractJob
+	)
 	for _, f := range reader.File {
-		if opts.Skip(f.Name) {
+		if o.Skip(f.Name) {
 			continue
 		}
+		if f.Mode().IsDir() {
+			dirs = append(dirs, f)
+		}
+		if o.HardLinks && f.Mode().IsRegular() {
+			// after the files they are links of
+			if _, ok := FileHardLink(f); ok {
+				links = append(links, f)
+				continue
+			}
+			files[f.Name] = f
+		}
+		jobs = append(jobs, &extractJob{file: f})
+	}
+	if o.ReadConcurrency > 0 {
+		sortByOffset(jobs)
+	}
+	for _, job := range jobs {
+		if o.ReadConcurrency > 0 && !job.file.Mode().IsDir() {
+			job.ahead = newReadAhead()
+		}
 		// stop submit, wait error
-		if submitErr := worker.Submit(f); submitErr != nil {
+		if submitErr := submit(job); submitErr != nil {
 			break
 		}
 	}
 
-	return worker.Wait()
+	// both workers are waited for, the extraction may still run when the reads fail
+	var err error
+	if o.ReadConcurrency > 0 {
+		err = readWorker.Wait()
+	}
+	// a failed extraction is also the error of the reads that submit to it
+	if extractErr := worker.Wait(); extractErr != nil && extractErr != err {
+		err = errors.Join(err, extractErr)
+	}
+	if err != nil {
+		return err
+	}
+	for _, f := range links {
+		t, err := o.extractHardLink(f, files)
+		if err != nil {
+			return err
+		}
+		if o.After != nil {
+			o.After(f, t)
+		}
+	}
+	// subdirectories are after their parents
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := o.restoreTimes(o.targetPath(dirs[i]), dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func GetComment(path string) (string, error) {
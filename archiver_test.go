@@ -2,11 +2,19 @@ package pzip
 
 import (
 	"archive/zip"
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestArchiver_ArchiveAll(t *testing.T) {
@@ -146,3 +154,213 @@ func TestExtract(t *testing.T) {
 	}
 
 }
+
+func writeTestTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// checkMemFS checks the content of the files of the tree at root extracted to mfs.
+func checkMemFS(t *testing.T, mfs *MemFS, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if f, ok := mfs.files[memName(filepath.Join(root, name))]; !ok || string(f.data) != content {
+			t.Errorf("%s: content mismatch", name)
+		}
+	}
+}
+
+func readTestArchive(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string, len(r.File))
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatalf("read %s: %s", f.Name, err)
+		}
+		got[f.Name] = string(b)
+	}
+	return got
+}
+
+func TestArchiveTo(t *testing.T) {
+	// larger than defaultBufSize and incompressible, so it overflows
+	random := make([]byte, defaultBufSize+1024)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	files := map[string]string{
+		"a.txt":        "hello",
+		"dir/b.txt":    strings.Repeat("hello world\n", 1000),
+		"dir/c/d.json": `{"hello": "world"}`,
+		"random.bin":   string(random),
+	}
+	root := writeTestTree(t, files)
+	tempDir := t.TempDir()
+
+	buf := new(bytes.Buffer)
+	err := ArchiveTo(context.Background(), buf, &ArchiveOptions{
+		Files:       []string{root},
+		Recurse:     true,
+		Concurrency: 2,
+		Level:       -1,
+		TempDir:     tempDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := readTestArchive(t, buf.Bytes())
+	for name, content := range files {
+		key := HeaderName(filepath.Join(root, name))
+		if got[key] != content {
+			t.Errorf("%s: got %q, want %q", key, got[key], content)
+		}
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("temp dir not cleaned up: %d entries left", len(entries))
+	}
+}
+
+func TestArchiveTo_SystemTempDir(t *testing.T) {
+	root := writeTestTree(t, map[string]string{
+		"a.txt":              "hello",
+		overflowPrefix + "1": "not an overflow file",
+	})
+	// overflow files are created in the system temp dir, which is archived
+	t.Setenv("TMPDIR", root)
+	t.Setenv("TMP", root)
+	t.Setenv("TEMP", root)
+
+	buf := new(bytes.Buffer)
+	if err := ArchiveTo(context.Background(), buf, &ArchiveOptions{Files: []string{root}, Recurse: true, Concurrency: 1}); err != nil {
+		t.Fatal(err)
+	}
+	got := readTestArchive(t, buf.Bytes())
+	if got[HeaderName(filepath.Join(root, overflowPrefix+"1"))] != "not an overflow file" {
+		t.Error("got a file with the prefix of overflow files skipped")
+	}
+	if got[HeaderName(filepath.Join(root, "a.txt"))] != "hello" {
+		t.Error("a.txt: content mismatch")
+	}
+
+	// only the overflow files created are skipped
+	o := &ArchiveOptions{temps: new(tempFiles)}
+	f, err := o.temps.create(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if !o.isTemp(f.Name()) || o.isTemp(filepath.Join(root, overflowPrefix+"1")) {
+		t.Error("got other files than the overflow files created skipped")
+	}
+}
+
+func TestArchiveTo_FSAndEntries(t *testing.T) {
+	fsys := fstest.MapFS{
+		"report/a.txt":    {Data: []byte("hello"), Mode: 0644},
+		"report/b.csv":    {Data: []byte(strings.Repeat("id,name\n", 1000)), Mode: 0644},
+		"report/img/c.md": {Data: []byte("# hello"), Mode: 0644},
+	}
+	export := strings.Repeat("INSERT INTO t VALUES (1);\n", 1000)
+
+	buf := new(bytes.Buffer)
+	err := ArchiveTo(context.Background(), buf, &ArchiveOptions{
+		FS:          fsys,
+		Files:       []string{"report"},
+		Recurse:     true,
+		Concurrency: 2,
+		Level:       -1,
+		Entries: []Entry{
+			{Name: "export.sql", Size: int64(len(export)), Mode: 0644, Reader: strings.NewReader(export)},
+			{Name: "small.txt", Size: 5, Mode: 0644, Reader: strings.NewReader("small")},
+			{Name: "generated/", Mode: fs.ModeDir | 0755},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"export.sql": export,
+		"small.txt":  "small",
+	}
+	for name, f := range fsys {
+		want[name] = string(f.Data)
+	}
+	got := readTestArchive(t, buf.Bytes())
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %d entries, want %d", len(got), len(want))
+		for name := range want {
+			if got[name] != want[name] {
+				t.Errorf("%s: content mismatch", name)
+			}
+		}
+	}
+}
+
+func TestArchiveTo_UnknownSize(t *testing.T) {
+	logs := strings.Repeat("GET / HTTP/1.1 200\n", 10000)
+	nested := strings.Repeat("PK", 100)
+
+	buf := new(bytes.Buffer)
+	err := ArchiveTo(context.Background(), buf, &ArchiveOptions{
+		Concurrency: 2,
+		Level:       -1,
+		Entries: []Entry{
+			{Name: "access.log", Size: -1, Mode: 0644, Reader: strings.NewReader(logs)},
+			{Name: "nested.zip", Size: -1, Mode: 0644, Reader: strings.NewReader(nested)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.File {
+		descriptor := f.Flags&0x8 != 0
+		switch f.Name {
+		case "access.log":
+			if !descriptor || f.Method != zip.Deflate || f.UncompressedSize64 != uint64(len(logs)) {
+				t.Errorf("%s: want deflated with data descriptor, got flags %#x method %d size %d", f.Name, f.Flags, f.Method, f.UncompressedSize64)
+			}
+		case "nested.zip":
+			if descriptor || f.Method != zip.Store || f.UncompressedSize64 != uint64(len(nested)) {
+				t.Errorf("%s: want stored without data descriptor, got flags %#x method %d size %d", f.Name, f.Flags, f.Method, f.UncompressedSize64)
+			}
+		}
+	}
+
+	got := readTestArchive(t, buf.Bytes())
+	if got["access.log"] != logs || got["nested.zip"] != nested {
+		t.Error("content mismatch")
+	}
+}
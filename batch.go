@@ -0,0 +1,70 @@
+package pzip
+
+import "sync"
+
+const (
+	// defaultBatchFileSize is the largest file batched by default.
+	defaultBatchFileSize = 64 << 10
+	// batchSlack is the buffer of batched files beyond their size, for the
+	// data of incompressible files that deflate or encryption adds.
+	batchSlack = 4 << 10
+)
+
+// smallPools are the pools of batched files by buffer size, shared with other
+// archives like DefaultObjectPool.
+var smallPools sync.Map
+
+func smallPool(bufSize int64) *ObjectPool {
+	if pool, ok := smallPools.Load(bufSize); ok {
+		return pool.(*ObjectPool)
+	}
+	pool, _ := smallPools.LoadOrStore(bufSize, NewObjectPoolSize(bufSize))
+	return pool.(*ObjectPool)
+}
+
+// batcher groups the small files of the walk into batches of size files,
+// which are compressed by one worker and written back to back.
+type batcher struct {
+	size int
+	head *Object
+}
+
+// add adds obj to the batch, and returns the batch once it is full.
+func (b *batcher) add(obj *Object) *Object {
+	if b.head == nil {
+		b.head = obj
+		return nil
+	}
+	if b.head.batch == nil {
+		b.head.batch = append(make([]*Object, 0, b.size), b.head)
+	}
+	b.head.batch = append(b.head.batch, obj)
+	if len(b.head.batch) < b.size {
+		return nil
+	}
+	return b.flush()
+}
+
+// flush returns the batch being filled, or nil if it is empty.
+func (b *batcher) flush() *Object {
+	head := b.head
+	b.head = nil
+	return head
+}
+
+// batched returns the objects of the batch of o, or o if it is not batched.
+func (o *Object) batched() []*Object {
+	if o.batch == nil {
+		return []*Object{o}
+	}
+	return o.batch
+}
+
+// takeCompressors takes the compressors of from, the objects of a batch
+// are compressed one after another with the same ones.
+func (o *Object) takeCompressors(from *Object) {
+	o.compressor, from.compressor = from.compressor, o.compressor
+	o.compressorLevel, from.compressorLevel = from.compressorLevel, o.compressorLevel
+	o.zstdEncoder, from.zstdEncoder = from.zstdEncoder, o.zstdEncoder
+	o.zstdLevel, from.zstdLevel = from.zstdLevel, o.zstdLevel
+}
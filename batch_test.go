@@ -0,0 +1,99 @@
+package pzip
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestArchive_Batch(t *testing.T) {
+	root := makeTree(t, 30, 10)
+	big := []byte(strings.Repeat("a larger file that is not batched\n", 10000))
+	if err := os.WriteFile(filepath.Join(root, "d1", "big.txt"), big, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// hard links of batched files
+	if err := os.Link(filepath.Join(root, "d1", "e1", "f1.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	newOpts := func() *ArchiveOptions {
+		return &ArchiveOptions{Files: []string{root}, Recurse: true, Concurrency: 4}
+	}
+	want := archiveNames(t, &ArchiveOptions{Files: []string{root}, Recurse: true, Concurrency: 1})
+
+	for _, batchSize := range []int{2, 7, 64} {
+		opts := newOpts()
+		opts.BatchSize = batchSize
+		opts.Ordered = true
+		if got := archiveNames(t, opts); !slices.Equal(got, want) {
+			t.Errorf("batch size %d: got %d entries not in the order of the walk, want %d", batchSize, len(got), len(want))
+		}
+		if stats := opts.Stats(); stats.Entries != len(want) || stats.EntriesPerSecond() <= 0 {
+			t.Errorf("batch size %d: got stats %+v", batchSize, stats)
+		}
+	}
+
+	opts := newOpts()
+	opts.BatchSize = 16
+	opts.BatchFileSize = 1 << 20
+	opts.MaxMemory = 8 << 20
+	opts.Dedup = true
+	path := filepath.Join(t.TempDir(), "test.zip")
+	if err := Archive(context.Background(), path, opts); err != nil {
+		t.Fatal(err)
+	}
+	mfs := NewMemFS()
+	if err := Extract(context.Background(), path, &ExtractOptions{Concurrency: 2, FS: mfs}); err != nil {
+		t.Fatal(err)
+	}
+	if f := mfs.files[memName(filepath.Join(root, "d1", "big.txt"))]; f == nil || !bytes.Equal(f.data, big) {
+		t.Error("big.txt: content mismatch")
+	}
+	// identical files of the batches
+	for _, name := range []string{"f3.txt", "f4.txt"} {
+		path := filepath.Join(root, "d2", "e2", name)
+		if f := mfs.files[memName(path)]; f == nil || string(f.data) != filepath.Dir(path) {
+			t.Errorf("%s: content mismatch", name)
+		}
+	}
+	if stats := opts.Stats(); stats.Duplicates == 0 {
+		t.Error("got no duplicates")
+	}
+}
+
+func benchmarkArchiveBatch(b *testing.B, batchSize int) {
+	root := b.TempDir()
+	for i := 0; i < 2000; i++ {
+		dir := filepath.Join(root, string(rune('a'+i%20)))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, strings.Repeat("f", i%50+1)+".js"), bytes.Repeat([]byte{'x'}, i%200), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	path := filepath.Join(b.TempDir(), "bench.zip")
+	var entries float64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		opts := &ArchiveOptions{Files: []string{root}, Recurse: true, Concurrency: 4, BatchSize: batchSize}
+		if err := Archive(context.Background(), path, opts); err != nil {
+			b.Fatal(err)
+		}
+		entries += opts.Stats().EntriesPerSecond()
+	}
+	b.ReportMetric(entries/float64(b.N), "entries/s")
+}
+
+func BenchmarkArchive_NoBatch(b *testing.B) {
+	benchmarkArchiveBatch(b, 0)
+}
+
+func BenchmarkArchive_Batch(b *testing.B) {
+	benchmarkArchiveBatch(b, 64)
+}
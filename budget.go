@@ -0,0 +1,96 @@
+package pzip
+
+import (
+	"errors"
+	"sync"
+)
+
+// errBudgetStopped is returned by waits stopped by a failed worker.
+var errBudgetStopped = errors.New("budget wait stopped")
+
+// budget bounds the objects in flight, from the submission to the compress
+// worker until they are written, and the size of their overflow files.
+type budget struct {
+	// maxObjects and maxDisk are unlimited if 0.
+	maxObjects int
+	maxDisk    int64
+	// done stops waiting when a worker fails.
+	done <-chan struct{}
+
+	mu      sync.Mutex
+	objects int
+	// disk is the size of the overflow files of objects in flight, written
+	// is of those compressed already, which are released by the writer.
+	disk    int64
+	written int64
+	// changed is closed and replaced when objects or disk are released.
+	changed chan struct{}
+}
+
+func newBudget(maxObjects int, maxDisk int64, done <-chan struct{}) *budget {
+	return &budget{maxObjects: maxObjects, maxDisk: maxDisk, done: done, changed: make(chan struct{})}
+}
+
+// acquire waits until another object can be in flight.
+func (b *budget) acquire(obj *Object) error {
+	b.mu.Lock()
+	for b.maxObjects > 0 && b.objects >= b.maxObjects {
+		if err := b.wait(); err != nil {
+			return err
+		}
+	}
+	b.objects++
+	b.mu.Unlock()
+	obj.budget = b
+	return nil
+}
+
+// reserveDisk waits until the overflow file of obj can grow by n bytes. It
+// waits for objects compressed already only, the files being compressed
+// may exceed the budget together since they wait for each other otherwise.
+func (b *budget) reserveDisk(obj *Object, n int64) error {
+	b.mu.Lock()
+	for b.maxDisk > 0 && b.disk+n > b.maxDisk && b.written > 0 {
+		if err := b.wait(); err != nil {
+			return err
+		}
+	}
+	b.disk += n
+	b.mu.Unlock()
+	obj.tempDisk += n
+	return nil
+}
+
+// compressed marks the overflow file of obj as waiting for the writer.
+func (b *budget) compressed(obj *Object) {
+	b.mu.Lock()
+	b.written += obj.tempDisk
+	b.mu.Unlock()
+}
+
+// release releases obj and its overflow file, which is written or deleted.
+func (b *budget) release(obj *Object) {
+	b.mu.Lock()
+	b.objects--
+	b.disk -= obj.tempDisk
+	b.written -= obj.tempDisk
+	close(b.changed)
+	b.changed = make(chan struct{})
+	b.mu.Unlock()
+	obj.budget = nil
+	obj.tempDisk = 0
+}
+
+// wait waits for a release with b.mu held, and returns with it held unless
+// the workers fail.
+func (b *budget) wait() error {
+	changed := b.changed
+	b.mu.Unlock()
+	select {
+	case <-changed:
+		b.mu.Lock()
+		return nil
+	case <-b.done:
+		return errBudgetStopped
+	}
+}
@@ -0,0 +1,100 @@
+package pzip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestBudget(t *testing.T) {
+	done := make(chan struct{})
+	b := newBudget(1, 10, done)
+	first, second := new(Object), new(Object)
+	if err := b.acquire(first); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error)
+	go func() { acquired <- b.acquire(second) }()
+	select {
+	case <-acquired:
+		t.Fatal("acquired more objects than the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the files being compressed do not wait for each other
+	if err := b.reserveDisk(first, 20); err != nil {
+		t.Fatal(err)
+	}
+	b.compressed(first)
+	b.release(first)
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.reserveDisk(second, 8); err != nil {
+		t.Fatal(err)
+	}
+	b.compressed(second)
+	third := new(Object)
+	go func() { acquired <- b.reserveDisk(third, 8) }()
+	select {
+	case <-acquired:
+		t.Fatal("reserved more disk than the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(done)
+	if err := <-acquired; !errors.Is(err, errBudgetStopped) {
+		t.Errorf("got error %v, want %v", err, errBudgetStopped)
+	}
+}
+
+func TestArchiveTo_Budget(t *testing.T) {
+	random := make([]byte, 100<<10)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	var entries []Entry
+	for i := 0; i < 20; i++ {
+		entries = append(entries, Entry{Name: string(rune('a'+i)) + ".bin", Size: int64(len(random)), Mode: 0644, Open: openString(string(random))})
+	}
+	opts := &ArchiveOptions{
+		Concurrency: 4,
+		TempDir:     t.TempDir(),
+		Entries:     entries,
+		BufferSize:  16 << 10,
+		MaxMemory:   32 << 10,
+		MaxTempDisk: 128 << 10,
+		// the random data is deflated
+		Rules: []Rule{{Method: DeflateMethod}},
+	}
+	buf := new(bytes.Buffer)
+	if err := ArchiveTo(context.Background(), buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.File) != len(entries) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(entries))
+	}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil || !bytes.Equal(b, random) {
+			t.Errorf("%s: content mismatch, %v", f.Name, err)
+		}
+	}
+
+	opts.MaxMemory = 16 << 10
+	if err = ArchiveTo(context.Background(), io.Discard, opts); err == nil {
+		t.Error("got no error of max memory below twice the buffer size")
+	}
+}
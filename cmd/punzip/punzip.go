@@ -17,8 +17,16 @@ import (
 	"github.com/spf13/pflag"
 	gopkgversion "github.com/zdz1715/go-pkg-version"
 	"github.com/zdz1715/pzip"
+	"golang.org/x/term"
+	"golang.org/x/text/encoding"
 )
 
+// stdinName is the archive name that extracts the archive read from stdin.
+const stdinName = "-"
+
+// passwordEnv is the environment variable of the password.
+const passwordEnv = "PUNZIP_PASSWORD"
+
 type Options struct {
 	Concurrency int
 
@@ -28,6 +36,18 @@ type Options struct {
 	Dir            string
 	Includes       []string
 	Excludes       []string
+	Password       string
+	PasswordFile   string
+	NameEncoding   string
+	RestoreOwner   bool
+	NumericOwner   bool
+	OwnerMap       string
+	SkipTimes      bool
+	Xattrs         bool
+	HardLinks      bool
+
+	ReadConcurrency int
+	HDD             bool
 }
 
 func (o *Options) addFlags(flags *pflag.FlagSet) {
@@ -38,13 +58,24 @@ func (o *Options) addFlags(flags *pflag.FlagSet) {
 	flags.BoolVarP(&o.List, "list", "l", false, "列出压缩包内的文件清单")
 	flags.StringSliceVarP(&o.Excludes, "exclude", "x", o.Excludes, "排除匹配的文件，支持多个排除规则，如：-x '*.log'，-x '*.tmp'")
 	flags.StringSliceVarP(&o.Includes, "include", "i", o.Includes, "仅解压匹配的文件，支持多个包含规则，如：-i '*.yaml'，-i 'README.md'")
+	flags.StringVarP(&o.Password, "password", "P", "", "指定解密密码（不安全，会出现在进程列表中），也可通过环境变量 "+passwordEnv+" 指定，未指定时从终端输入")
+	flags.StringVar(&o.PasswordFile, "password-file", "", "从文件的第一行读取解密密码")
+	flags.StringVarP(&o.NameEncoding, "name-encoding", "O", "", "指定未标记 UTF-8 的文件名编码，如：-O GBK、-O Shift-JIS、-O CP437，未指定时自动识别")
+	flags.BoolVarP(&o.RestoreOwner, "restore-owner", "X", false, "恢复文件的所有者（UID/GID），需要 root 权限")
+	flags.BoolVar(&o.NumericOwner, "numeric-owner", false, "恢复所有者时使用压缩包中的 UID/GID，而不是按用户名和组名查找")
+	flags.StringVar(&o.OwnerMap, "owner-map", "", "恢复所有者时使用的映射文件，每行格式为 '旧 新'，均为 [用户][:组]，ID 以 + 开头，如：alice:staff bob:users、+1000 root")
+	flags.BoolVarP(&o.SkipTimes, "skip-times", "D", false, "不恢复文件和目录的修改时间与访问时间")
+	flags.BoolVar(&o.Xattrs, "xattrs", false, "恢复文件的扩展属性和 POSIX ACL，跳过无权设置或不支持的属性")
+	flags.IntVar(&o.ReadConcurrency, "read-concurrency", 0, "设置按压缩包内偏移顺序读取文件数据的并发数，文件按该顺序解压，默认为 0 即由解压线程读取")
+	flags.BoolVar(&o.HDD, "hdd", false, "机械硬盘模式，由单个线程按偏移顺序读取压缩包，可与 --read-concurrency 同时使用")
+	flags.BoolVar(&o.HardLinks, "hard-links", false, "将压缩时记录为硬链接的文件恢复为硬链接，而不是重复的文件")
 }
 
 func NewUnzipCommand(ctx context.Context) *cobra.Command {
 	ver := gopkgversion.NewVersionInfo()
 	opts := &Options{}
 	cmd := &cobra.Command{
-		Use:           "punzip [flags] file[.zip]",
+		Use:           "punzip [flags] file[.zip]|-",
 		Short:         "并发解压zip压缩包",
 		SilenceUsage:  true,
 		SilenceErrors: true,
@@ -53,7 +84,10 @@ func NewUnzipCommand(ctx context.Context) *cobra.Command {
 			if len(args) == 0 || args[0] == "" {
 				return cmd.Help()
 			}
-			name := pzip.FormatName(args[0])
+			name := args[0]
+			if name != stdinName {
+				name = pzip.FormatName(name)
+			}
 			err := RunUnZip(ctx, opts, name)
 			if err != nil {
 				return fmt.Errorf("%s (%s)", err, name)
@@ -66,6 +100,10 @@ func NewUnzipCommand(ctx context.Context) *cobra.Command {
 }
 
 func RunUnZip(ctx context.Context, opts *Options, name string) error {
+	if name == stdinName && (opts.DisplayComment || opts.List) {
+		return fmt.Errorf("can not list or display comment of stdin")
+	}
+
 	if opts.DisplayComment {
 		comment, err := pzip.GetComment(name)
 		if err != nil {
@@ -75,12 +113,21 @@ func RunUnZip(ctx context.Context, opts *Options, name string) error {
 		return nil
 	}
 
+	var nameEncoding encoding.Encoding
+	if opts.NameEncoding != "" {
+		var err error
+		if nameEncoding, err = pzip.LookupEncoding(opts.NameEncoding); err != nil {
+			return err
+		}
+	}
+
 	if opts.List {
 		reader, err := pzip.OpenReader(name)
 		if err != nil {
 			return err
 		}
 		defer reader.Close()
+		pzip.DecodeNames(reader.File, nameEncoding)
 		return printList(os.Stdout, name, reader)
 	}
 
@@ -95,7 +142,7 @@ func RunUnZip(ctx context.Context, opts *Options, name string) error {
 			md = "creating"
 		}
 
-		if f.Method == zip.Deflate {
+		if method := pzip.ActualMethod(f.Method, f.Extra); method == zip.Deflate || method == pzip.Zstd {
 			md = "inflating"
 		}
 
@@ -103,6 +150,10 @@ func RunUnZip(ctx context.Context, opts *Options, name string) error {
 			md = "symlinking"
 		}
 
+		if target.HardLink != "" {
+			md = "linking"
+		}
+
 		_, _ = fmt.Fprintf(os.Stdout, "  %s: %s\n", md, target)
 	}
 
@@ -111,16 +162,96 @@ func RunUnZip(ctx context.Context, opts *Options, name string) error {
 		after = nil
 	}
 
-	return pzip.Extract(ctx, name, &pzip.ExtractOptions{
-		Concurrency: opts.Concurrency,
-		Before:      before,
-		After:       after,
-		OutDir:      opts.Dir,
+	password, err := readPassword(opts)
+	if err != nil {
+		return err
+	}
+
+	ownerMap, err := readOwnerMap(opts)
+	if err != nil {
+		return err
+	}
+	if opts.RestoreOwner && os.Geteuid() != 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "warning: -X is ignored, restoring the owner requires root")
+		opts.RestoreOwner = false
+	}
+
+	// a single sequential reader suits hard disks
+	readConcurrency := opts.ReadConcurrency
+	if opts.HDD && readConcurrency == 0 {
+		readConcurrency = 1
+	}
+
+	extractOpts := &pzip.ExtractOptions{
+		Password:     password,
+		Prompt:       promptPassword,
+		Concurrency:  opts.Concurrency,
+		Before:       before,
+		After:        after,
+		OutDir:       opts.Dir,
+		NameEncoding: nameEncoding,
+		RestoreOwner: opts.RestoreOwner,
+		NumericOwner: opts.NumericOwner,
+		OwnerMap:     ownerMap,
+		SkipTimes:    opts.SkipTimes,
+		Xattrs:       opts.Xattrs,
+		HardLinks:    opts.HardLinks,
+
+		ReadConcurrency: readConcurrency,
 		SkipPath: pzip.SkipPath{
 			Includes: opts.Includes,
 			Excludes: opts.Excludes,
 		},
-	})
+	}
+
+	if name == stdinName {
+		if !opts.Quiet {
+			_, _ = fmt.Fprintf(os.Stdout, "Archive: %s\n", name)
+		}
+		return pzip.ExtractStream(ctx, os.Stdin, extractOpts)
+	}
+	return pzip.Extract(ctx, name, extractOpts)
+}
+
+// readPassword returns the password of -P, --password-file or the environment.
+func readPassword(opts *Options) (string, error) {
+	if opts.Password != "" {
+		return opts.Password, nil
+	}
+	if opts.PasswordFile != "" {
+		b, err := os.ReadFile(opts.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		password, _, _ := strings.Cut(string(b), "\n")
+		return strings.TrimSuffix(password, "\r"), nil
+	}
+	return os.Getenv(passwordEnv), nil
+}
+
+// readOwnerMap returns the owner map of --owner-map, or nil if not set.
+func readOwnerMap(opts *Options) (*pzip.OwnerMap, error) {
+	if opts.OwnerMap == "" {
+		return nil, nil
+	}
+	f, err := os.Open(opts.OwnerMap)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return pzip.ParseOwnerMap(f)
+}
+
+// promptPassword reads the password from the terminal.
+func promptPassword(name string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("%s is encrypted, use -P, --password-file or %s", name, passwordEnv)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "[%s] password: ", name)
+	password, err := term.ReadPassword(fd)
+	_, _ = fmt.Fprintln(os.Stderr)
+	return string(password), err
 }
 
 func printList(w io.Writer, name string, r *pzip.ReadCloser) error {
@@ -148,8 +279,11 @@ func printList(w io.Writer, name string, r *pzip.ReadCloser) error {
 		}
 
 		method := "Stored"
-		if v.Method == zip.Deflate {
+		switch pzip.ActualMethod(v.Method, v.Extra) {
+		case zip.Deflate:
 			method = "Defl:N"
+		case pzip.Zstd:
+			method = "Zstd"
 		}
 		var ratio float64
 		if v.UncompressedSize64 > v.CompressedSize64 {
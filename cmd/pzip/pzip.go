@@ -7,6 +7,8 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/zdz1715/pzip/flate"
 
@@ -14,8 +16,14 @@ import (
 	"github.com/spf13/pflag"
 	gopkgversion "github.com/zdz1715/go-pkg-version"
 	"github.com/zdz1715/pzip"
+	"golang.org/x/term"
+	"golang.org/x/text/encoding"
 )
 
+// stdioName is the output name that writes the archive to stdout,
+// and the file name that archives stdin.
+const stdioName = "-"
+
 type Options struct {
 	Recursive     bool
 	Excludes      []string
@@ -25,6 +33,33 @@ type Options struct {
 	Comment       string
 	NoDereference bool
 	Level         int
+	TempPath      string
+	Encrypt       bool
+	PasswordFile  string
+	SplitSize     string
+	NameEncoding  string
+	UnicodeExtra  bool
+	ExtraTimes    bool
+	Xattrs        bool
+	OwnerNames    bool
+	Dedup         bool
+	TrialSize     string
+	Rules         []string
+	RulesFile     string
+	StoreSuffixes string
+	BufferSize    string
+	MaxMemory     string
+	MaxTempDisk   string
+	DirectWrite   bool
+
+	ReadConcurrency int
+	HDD             bool
+	WalkConcurrency int
+	Ordered         bool
+	BatchSize       int
+	BatchFileSize   string
+	Stats           bool
+	Resume          bool
 }
 
 func (o *Options) addFlags(flags *pflag.FlagSet) {
@@ -36,13 +71,39 @@ func (o *Options) addFlags(flags *pflag.FlagSet) {
 	flags.StringSliceVarP(&o.Excludes, "exclude", "x", o.Excludes, "排除匹配的文件，支持多个排除规则，如：-x '*.log'，-x '*.tmp'")
 	flags.StringSliceVarP(&o.Includes, "include", "i", o.Includes, "仅包含匹配的文件，支持多个包含规则，如：-i '*.yaml' -i 'README.md'")
 	flags.StringVarP(&o.Comment, "comment", "z", "", "为整个 ZIP 文件添加注释")
+	flags.StringVarP(&o.TempPath, "temp-path", "b", "", "指定临时文件的存放目录，默认为输出文件所在目录，输出到标准输出时为系统临时目录")
+	flags.BoolVarP(&o.Encrypt, "encrypt", "e", false, "使用 AES-256 加密文件，从终端输入密码")
+	flags.StringVar(&o.PasswordFile, "password-file", "", "从文件的第一行读取密码，使用 AES-256 加密文件")
+	flags.StringVarP(&o.SplitSize, "split-size", "s", "", "创建分卷压缩包，指定每个分卷的最大大小，如：-s 2g，单位支持 k、m、g、t，最小为 64k")
+	flags.StringVarP(&o.NameEncoding, "name-encoding", "O", "", "使用指定编码写入文件名以兼容旧的解压工具，如：-O GBK，无法编码的文件名仍使用 UTF-8")
+	flags.BoolVar(&o.UnicodeExtra, "unicode-extra", false, "使用 -O 指定的编码（默认为 CP437）写入文件名，并在 Info-ZIP Unicode 扩展字段中保存 UTF-8 文件名，以兼容忽略 UTF-8 标志的解压工具")
+	flags.BoolVar(&o.ExtraTimes, "extra-times", false, "保存文件的访问时间和创建时间，并写入 NTFS 扩展字段以保留 100 纳秒精度的时间")
+	flags.BoolVar(&o.Xattrs, "xattrs", false, "保存文件的扩展属性和 POSIX ACL（仅 Linux）")
+	flags.BoolVar(&o.OwnerNames, "owner-names", false, "除 UID/GID 外保存所有者的用户名和组名，解压时优先按名称恢复所有者")
+	flags.BoolVar(&o.Dedup, "dedup", false, "对内容相同的文件只压缩一次，其余文件复用压缩后的数据")
+	flags.StringVar(&o.TrialSize, "trial-size", "", "试压缩无法识别格式的文件的前若干字节，压缩率低于 10% 时改为存储，如：--trial-size 64k")
+	flags.StringArrayVar(&o.Rules, "rule", o.Rules, "按文件名或大小指定压缩方法和级别，按顺序匹配第一条规则，如：--rule '*.log -> deflate 6' --rule 'size > 1g -> level 1' --rule 'assets/** -> zstd 3'，deflate 级别 7-9 会写出 unzip 无法解压的数据，暂不支持")
+	flags.StringVar(&o.RulesFile, "rules-file", "", "从文件读取压缩规则，每行一条，格式同 --rule，忽略空行和 # 开头的行")
+	flags.StringVar(&o.BufferSize, "buffer-size", "", "每个文件压缩数据的内存缓冲区大小，超出部分写入临时文件，默认为 2m")
+	flags.StringVar(&o.MaxMemory, "max-memory", "", "限制压缩中和等待写入的文件占用的缓冲区总大小，超出时暂停压缩新文件，至少为缓冲区大小的两倍，如：--max-memory 512m")
+	flags.StringVar(&o.MaxTempDisk, "max-temp-disk", "", "限制等待写入的文件占用的临时文件总大小，超出时等待写入，正在压缩的文件可能超出该限制，如：--max-temp-disk 10g")
+	flags.BoolVar(&o.DirectWrite, "direct-write", false, "由压缩线程直接将文件数据写入压缩包，存储的文件并行写入，超出缓冲区的压缩数据不再写入临时文件，不支持分卷和标准输出")
+	flags.IntVar(&o.ReadConcurrency, "read-concurrency", 0, "设置顺序读取文件的并发数，读取的数据交给压缩线程，默认为 0 即由压缩线程读取")
+	flags.BoolVar(&o.HDD, "hdd", false, "机械硬盘模式，按目录内文件的 inode 顺序由单个线程顺序读取，可与 --read-concurrency 同时使用")
+	flags.IntVar(&o.WalkConcurrency, "walk-concurrency", 0, "设置并行遍历目录和读取文件信息的并发数，适用于包含大量小文件的目录，文件顺序不变，默认为 0 即单线程遍历")
+	flags.BoolVar(&o.Ordered, "ordered", false, "按遍历顺序写入文件，使压缩包内的文件顺序保持确定，不支持 --direct-write")
+	flags.IntVar(&o.BatchSize, "batch-size", 0, "将多个小文件合并为一个任务压缩并连续写入，指定每批的文件数，适用于包含大量小文件的目录，默认为 0 即不合并")
+	flags.StringVar(&o.BatchFileSize, "batch-file-size", "", "合并压缩的小文件的最大大小，默认为 64k")
+	flags.BoolVar(&o.Stats, "stats", false, "压缩完成后显示写入的文件数、耗时和每秒写入的文件数")
+	flags.BoolVar(&o.Resume, "resume", false, "可恢复的压缩，中断后保留已写入的部分压缩包和日志，再次使用相同参数运行时跳过大小和修改时间未变的文件并继续压缩，已变化文件的旧数据留在压缩包中但不在中央目录里，不支持分卷、--direct-write 和标准输出")
+	flags.StringVarP(&o.StoreSuffixes, "suffixes", "n", "", "直接存储以指定后缀结尾的文件，不区分大小写，以 : 分隔，如：-n .mp3:.jpg")
 }
 
 func NewPzipCommand(ctx context.Context) *cobra.Command {
 	ver := gopkgversion.NewVersionInfo()
 	opts := &Options{}
 	cmd := &cobra.Command{
-		Use:           "pzip [flags] file[.zip] [file...]",
+		Use:           "pzip [flags] file[.zip]|- [file...]",
 		Short:         "并发压缩文件至zip格式",
 		SilenceUsage:  true,
 		SilenceErrors: true,
@@ -51,7 +112,10 @@ func NewPzipCommand(ctx context.Context) *cobra.Command {
 			if len(args) == 0 || args[0] == "" {
 				return cmd.Help()
 			}
-			name := pzip.FormatName(args[0])
+			name := args[0]
+			if name != stdioName {
+				name = pzip.FormatName(name)
+			}
 			if len(args) < 2 {
 				return fmt.Errorf("nothing to do! (%s)", name)
 			}
@@ -67,24 +131,115 @@ func NewPzipCommand(ctx context.Context) *cobra.Command {
 }
 
 func RunZip(ctx context.Context, opts *Options, name string, paths []string) error {
+	// the archive itself goes to stdout, so logs go to stderr
+	var logOut io.Writer = os.Stdout
+	if name == stdioName {
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			return fmt.Errorf("refusing to write archive to terminal")
+		}
+		logOut = os.Stderr
+	}
+
+	var nameEncoding encoding.Encoding
+	if opts.NameEncoding != "" {
+		var err error
+		if nameEncoding, err = pzip.LookupEncoding(opts.NameEncoding); err != nil {
+			return err
+		}
+	}
+
 	after := func(hdr *pzip.FileHeader) {
 		md := "stored"
-		if hdr.Method == zip.Deflate {
+		switch pzip.ActualMethod(hdr.Method, hdr.Extra) {
+		case zip.Deflate:
 			md = "deflated"
+		case pzip.Zstd:
+			md = "zstd"
+		}
+		name := hdr.Name
+		if hdr.NonUTF8 {
+			f := new(pzip.File)
+			f.Name, f.Flags, f.Extra = hdr.Name, hdr.Flags, hdr.Extra
+			pzip.DecodeNames([]*pzip.File{f}, nameEncoding)
+			name = f.Name
 		}
-		_, _ = fmt.Printf("  adding: %s (%s)\n", hdr.Name, md)
+		_, _ = fmt.Fprintf(logOut, "  adding: %s (%s)\n", name, md)
 	}
 
 	if opts.Quiet {
 		after = nil
 	}
 
-	return pzip.Archive(ctx, name, &pzip.ArchiveOptions{
+	files := make([]string, 0, len(paths))
+	var entries []pzip.Entry
+	for _, path := range paths {
+		if path != stdioName {
+			files = append(files, path)
+			continue
+		}
+		entries = append(entries, pzip.Entry{
+			Name:     stdioName,
+			Size:     -1,
+			Mode:     0644,
+			Modified: time.Now(),
+			Reader:   os.Stdin,
+		})
+	}
+
+	password, err := readPassword(opts)
+	if err != nil {
+		return err
+	}
+
+	splitSize, err := parseSize(opts.SplitSize)
+	if err != nil {
+		return err
+	}
+	if splitSize > 0 && name == stdioName {
+		return fmt.Errorf("can not split the archive written to stdout")
+	}
+
+	trialSize, err := parseSize(opts.TrialSize)
+	if err != nil {
+		return err
+	}
+
+	bufferSize, err := parseSize(opts.BufferSize)
+	if err != nil {
+		return err
+	}
+	maxMemory, err := parseSize(opts.MaxMemory)
+	if err != nil {
+		return err
+	}
+	maxTempDisk, err := parseSize(opts.MaxTempDisk)
+	if err != nil {
+		return err
+	}
+
+	batchFileSize, err := parseSize(opts.BatchFileSize)
+	if err != nil {
+		return err
+	}
+
+	rules, err := readRules(opts)
+	if err != nil {
+		return err
+	}
+
+	// a single sequential reader suits hard disks
+	readConcurrency := opts.ReadConcurrency
+	if opts.HDD && readConcurrency == 0 {
+		readConcurrency = 1
+	}
+
+	archiveOpts := &pzip.ArchiveOptions{
 		NewCompressor: func(w io.Writer, level int) (flate.Writer, error) {
 			return flate.NewFastWriter(w, level)
 		},
 		Concurrency: opts.Concurrency,
-		Files:       paths,
+		Files:       files,
+		Entries:     entries,
 		SkipPath: pzip.SkipPath{
 			Includes: opts.Includes,
 			Excludes: opts.Excludes,
@@ -94,7 +249,134 @@ func RunZip(ctx context.Context, opts *Options, name string, paths []string) err
 		Level:       opts.Level,
 		Comment:     opts.Comment,
 		Recurse:     opts.Recursive,
-	})
+		TempDir:     opts.TempPath,
+		Password:    password,
+		SplitSize:   splitSize,
+
+		NameEncoding: nameEncoding,
+		UnicodeExtra: opts.UnicodeExtra,
+		ExtraTimes:   opts.ExtraTimes,
+		Xattrs:       opts.Xattrs,
+		OwnerNames:   opts.OwnerNames,
+		Dedup:        opts.Dedup,
+		TrialSize:    int(trialSize),
+		Rules:        rules,
+		BufferSize:   bufferSize,
+		MaxMemory:    maxMemory,
+		MaxTempDisk:  maxTempDisk,
+		DirectWrite:  opts.DirectWrite,
+
+		ReadConcurrency: readConcurrency,
+		InodeOrder:      opts.HDD,
+		WalkConcurrency: opts.WalkConcurrency,
+		Ordered:         opts.Ordered,
+		BatchSize:       opts.BatchSize,
+		BatchFileSize:   batchFileSize,
+		Resume:          opts.Resume,
+	}
+
+	if name == stdioName {
+		err = pzip.ArchiveTo(ctx, os.Stdout, archiveOpts)
+	} else {
+		err = pzip.Archive(ctx, name, archiveOpts)
+	}
+	if err != nil {
+		return err
+	}
+	stats := archiveOpts.Stats()
+	if !opts.Quiet && stats.Resumed > 0 {
+		_, _ = fmt.Fprintf(logOut, "resumed: %d entries kept\n", stats.Resumed)
+	}
+	if !opts.Quiet && stats.Duplicates > 0 {
+		_, _ = fmt.Fprintf(logOut, "deduplicated: %d files, %d bytes saved\n", stats.Duplicates, stats.SavedBytes)
+	}
+	if opts.Stats {
+		_, _ = fmt.Fprintf(logOut, "archived: %d entries in %s, %.0f entries/s\n",
+			stats.Entries, stats.Elapsed.Round(time.Millisecond), stats.EntriesPerSecond())
+	}
+	return nil
+}
+
+// readRules returns the rules of -n, --rules-file and --rule, in this order.
+func readRules(opts *Options) ([]pzip.Rule, error) {
+	rules := pzip.StoreSuffixes(opts.StoreSuffixes)
+	if opts.RulesFile != "" {
+		f, err := os.Open(opts.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		fileRules, err := pzip.ParseRules(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", opts.RulesFile, err)
+		}
+		rules = append(rules, fileRules...)
+	}
+	for _, s := range opts.Rules {
+		rule, err := pzip.ParseRule(s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseSize parses a size such as 100m or 2g of a flag, which is 0 if empty.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	size, err := pzip.ParseSize(s)
+	if err != nil {
+		return 0, err
+	}
+	if size <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return size, nil
+}
+
+// readPassword returns the password of --password-file, or prompts for it with -e.
+func readPassword(opts *Options) (string, error) {
+	if opts.PasswordFile != "" {
+		b, err := os.ReadFile(opts.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		password, _, _ := strings.Cut(string(b), "\n")
+		password = strings.TrimSuffix(password, "\r")
+		if password == "" {
+			return "", fmt.Errorf("empty password in %s", opts.PasswordFile)
+		}
+		return password, nil
+	}
+	if !opts.Encrypt {
+		return "", nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("-e requires a terminal to enter the password, use --password-file instead")
+	}
+	_, _ = fmt.Fprint(os.Stderr, "Enter password: ")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	_, _ = fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	_, _ = fmt.Fprint(os.Stderr, "Verify password: ")
+	verify, err := term.ReadPassword(int(os.Stdin.Fd()))
+	_, _ = fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(password) != string(verify) {
+		return "", fmt.Errorf("password verification failed")
+	}
+	if len(password) == 0 {
+		return "", fmt.Errorf("empty password")
+	}
+	return string(password), nil
 }
 
 func main() {
@@ -0,0 +1,62 @@
+package pzip
+
+import (
+	"context"
+	"io"
+)
+
+// copyChunk is the most copied between checks of the context, large enough
+// for the fast paths of io.CopyN between files.
+const copyChunk = 4 << 20
+
+// copyContext copies src to dst like io.Copy, and stops with the error of
+// ctx once it is done.
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		n, err := io.CopyN(dst, src, copyChunk)
+		written += n
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// copyNContext copies n bytes from src to dst like io.CopyN, and stops with
+// the error of ctx once it is done.
+func copyNContext(ctx context.Context, dst io.Writer, src io.Reader, n int64) (int64, error) {
+	var written int64
+	for written < n {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		m, err := io.CopyN(dst, src, min(copyChunk, n-written))
+		written += m
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// context returns the context of the archive o is submitted to.
+func (o *Object) context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
+
+// context returns the context of the running extraction.
+func (o *ExtractOptions) context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
@@ -0,0 +1,154 @@
+package pzip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cancelReader cancels its context after n bytes are read.
+type cancelReader struct {
+	r      io.Reader
+	n      int64
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.n -= int64(n); r.n <= 0 {
+		r.cancel()
+	}
+	return n, err
+}
+
+func TestCopyContext(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), copyChunk/4)
+
+	var buf bytes.Buffer
+	n, err := copyContext(context.Background(), &buf, bytes.NewReader(data))
+	if err != nil || n != int64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("got %d bytes, %v", n, err)
+	}
+	buf.Reset()
+	n, err = copyNContext(context.Background(), &buf, bytes.NewReader(data), copyChunk+10)
+	if err != nil || n != copyChunk+10 {
+		t.Fatalf("got %d bytes, %v", n, err)
+	}
+
+	// the copies stop at the chunk the context is canceled in
+	ctx, cancel := context.WithCancel(context.Background())
+	src := &cancelReader{r: bytes.NewReader(data), n: 1, cancel: cancel}
+	if n, err = copyContext(ctx, io.Discard, src); !errors.Is(err, context.Canceled) || n != copyChunk {
+		t.Errorf("got %d bytes, %v", n, err)
+	}
+	if n, err = copyNContext(ctx, io.Discard, bytes.NewReader(data), int64(len(data))); !errors.Is(err, context.Canceled) || n != 0 {
+		t.Errorf("got %d bytes, %v", n, err)
+	}
+}
+
+func TestArchive_Canceled(t *testing.T) {
+	dir := t.TempDir()
+	random := make([]byte, 2<<20)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	for i := 0; i < 16; i++ {
+		path := filepath.Join(dir, "src", strings.Repeat("d", i%4), "f"+string(rune('a'+i)))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, random, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(dir, "out")
+	tempDir := filepath.Join(dir, "tmp")
+	for _, dir := range []string{out, tempDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := &ArchiveOptions{
+		Files:       []string{filepath.Join(dir, "src")},
+		Recurse:     true,
+		Concurrency: 4,
+		// the files overflow the buffers into the temp dir
+		BufferSize: 64 << 10,
+		TempDir:    tempDir,
+		After: func(hdr *FileHeader) {
+			if !hdr.Mode().IsDir() {
+				cancel()
+			}
+		},
+	}
+	if err := Archive(ctx, filepath.Join(out, "test.zip"), opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	for _, dir := range []string{out, tempDir} {
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("%s: got %d files left", dir, len(entries))
+		}
+	}
+
+	// the workers are idle when the walk stops
+	opts.After = nil
+	if err := Archive(ctx, filepath.Join(out, "test.zip"), opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v of a canceled context, want %v", err, context.Canceled)
+	}
+}
+
+func TestExtract_Canceled(t *testing.T) {
+	dir := t.TempDir()
+	random := make([]byte, 3*copyChunk)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	if err := os.WriteFile(filepath.Join(dir, "random.bin"), random, 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.zip")
+	if err := Archive(context.Background(), path, &ArchiveOptions{Files: []string{filepath.Join(dir, "random.bin")}, Concurrency: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the extraction is canceled once the file is being written
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mfs := NewMemFS()
+	opts := &ExtractOptions{Concurrency: 1, FS: &cancelFS{MemFS: mfs, cancel: cancel}}
+	if err := Extract(ctx, path, opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if _, ok := mfs.files[memName(filepath.Join(dir, "random.bin"))]; ok {
+		t.Error("got a partial file left")
+	}
+}
+
+// cancelFS cancels the context of an extraction once a file is written.
+type cancelFS struct {
+	*MemFS
+	cancel context.CancelFunc
+}
+
+func (c *cancelFS) OpenFile(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+	w, err := c.MemFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &cancelWriter{WriteCloser: w, cancel: c.cancel}, nil
+}
+
+type cancelWriter struct {
+	io.WriteCloser
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	return w.WriteCloser.Write(p)
+}
@@ -0,0 +1,411 @@
+package pzip
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	ErrPasswordRequired = errors.New("zip: password required")
+	ErrPassword         = errors.New("zip: incorrect password")
+	ErrAuthentication   = errors.New("zip: authentication code mismatch")
+)
+
+// WinZip AES encryption.
+// reference: https://www.winzip.com/en/support/aes-encryption/
+const (
+	aesMethod  = 99
+	aesExtraID = 0x9901
+
+	aesVendorVersion1 = 1      // AE-1, the crc32 is stored
+	aesVendorVersion  = 2      // AE-2, the crc32 is not stored
+	aesVendorID       = 0x4541 // "AE"
+	aesStrength       = 3      // AES-256
+
+	aesSaltLen     = 16
+	aesVerifierLen = 2
+	aesAuthCodeLen = 10
+	aesIterations  = 1000
+	aesExtraLen    = 11 // 2x uint16 + 7 bytes of data
+)
+
+// aesKeys derives the encryption key, the authentication key and the
+// password verifier from password and salt, the keys are twice as long as salt.
+func aesKeys(password string, salt []byte) (encKey, macKey, verifier []byte) {
+	keyLen := 2 * len(salt)
+	dk := pbkdf2.Key([]byte(password), salt, aesIterations, 2*keyLen+aesVerifierLen, sha1.New)
+	return dk[:keyLen], dk[keyLen : 2*keyLen], dk[2*keyLen:]
+}
+
+// aesExtra returns the AES extra field of an entry compressed with method.
+func aesExtra(method uint16) []byte {
+	var buf [aesExtraLen]byte
+	b := writeBuf(buf[:])
+	b.uint16(aesExtraID)
+	b.uint16(aesExtraLen - 4)
+	b.uint16(aesVendorVersion)
+	b.uint16(aesVendorID)
+	b.uint8(aesStrength)
+	b.uint16(method)
+	return buf[:]
+}
+
+// aesCTR is AES in counter mode with the little-endian counter of WinZip,
+// which starts at 1.
+type aesCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [32 * aes.BlockSize]byte
+	pos     int
+}
+
+func newAESCTR(key []byte) (*aesCTR, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	c := &aesCTR{block: block}
+	c.pos = len(c.stream)
+	return c, nil
+}
+
+func (c *aesCTR) refill() {
+	for i := 0; i < len(c.stream); i += aes.BlockSize {
+		for j := range c.counter {
+			c.counter[j]++
+			if c.counter[j] != 0 {
+				break
+			}
+		}
+		c.block.Encrypt(c.stream[i:], c.counter[:])
+	}
+	c.pos = 0
+}
+
+func (c *aesCTR) XORKeyStream(dst, src []byte) {
+	for len(src) > 0 {
+		if c.pos == len(c.stream) {
+			c.refill()
+		}
+		n := subtle.XORBytes(dst, src, c.stream[c.pos:])
+		c.pos += n
+		dst = dst[n:]
+		src = src[n:]
+	}
+}
+
+// aesEncrypter encrypts the data of an entry, which is written as the salt,
+// the password verifier, the encrypted data and the authentication code.
+type aesEncrypter struct {
+	salt     []byte
+	verifier []byte
+	ctr      cipher.Stream
+	mac      hash.Hash
+	buf      []byte
+}
+
+func newAESEncrypter(password string) (*aesEncrypter, error) {
+	salt := make([]byte, aesSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generate salt: %w", err)
+	}
+	encKey, macKey, verifier := aesKeys(password, salt)
+	ctr, err := newAESCTR(encKey)
+	if err != nil {
+		return nil, err
+	}
+	return &aesEncrypter{
+		salt:     salt,
+		verifier: verifier,
+		ctr:      ctr,
+		mac:      hmac.New(sha1.New, macKey),
+	}, nil
+}
+
+// header returns the salt and the password verifier.
+func (e *aesEncrypter) header() []byte {
+	return append(e.salt[:len(e.salt):len(e.salt)], e.verifier...)
+}
+
+// encrypt returns the encrypted p, which is valid until the next call.
+func (e *aesEncrypter) encrypt(p []byte) []byte {
+	if cap(e.buf) < len(p) {
+		e.buf = make([]byte, len(p))
+	}
+	buf := e.buf[:len(p)]
+	e.ctr.XORKeyStream(buf, p)
+	e.mac.Write(buf)
+	return buf
+}
+
+// authCode returns the authentication code of the encrypted data.
+func (e *aesEncrypter) authCode() []byte {
+	return e.mac.Sum(nil)[:aesAuthCodeLen]
+}
+
+// decrypter decrypts the data of an entry.
+type decrypter interface {
+	byteReader
+	// finish checks the authentication code following the data.
+	finish() error
+}
+
+// newDecrypter returns the decrypter of file whose raw data of size bytes is read
+// from raw, the compression method of the data and whether the crc32 is stored.
+// If size is negative the end of the data is unknown, it is read byte by byte
+// from raw, which must be an io.ByteReader, until the decompressor ends.
+func newDecrypter(file *File, raw io.Reader, password string, size int64) (d decrypter, method uint16, hasCRC bool, err error) {
+	if password == "" {
+		return nil, 0, false, ErrPasswordRequired
+	}
+	if file.Method == aesMethod {
+		return newAESReader(file, raw, password, size)
+	}
+	d, err = newZipCryptoReader(file, raw, password, size)
+	return d, file.Method, true, err
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// dataReader returns the reader of the n bytes data after the header, or raw
+// if n is negative.
+func dataReader(raw io.Reader, n int64) byteReader {
+	if n < 0 {
+		return raw.(byteReader)
+	}
+	return bufio.NewReader(io.LimitReader(raw, n))
+}
+
+// openEncrypted returns the uncompressed content of the encrypted file,
+// whose raw data is read from raw.
+func openEncrypted(file *File, raw io.Reader, password string) (io.ReadCloser, error) {
+	d, method, hasCRC, err := newDecrypter(file, raw, password, int64(file.CompressedSize64))
+	if err != nil {
+		return nil, err
+	}
+
+	dcomp := decompressor(method)
+	if dcomp == nil {
+		return nil, ErrAlgorithm
+	}
+	rc := dcomp(bufio.NewReader(d))
+
+	var r io.Reader = rc
+	if hasCRC {
+		r = &checksumReader{rc: rc, hash: crc32.NewIEEE(), file: file}
+	}
+	return &drainReader{Reader: r, Closer: rc, d: d}, nil
+}
+
+// drainReader reads the rest of the data at EOF and checks its authentication
+// code, the decompressor may not read the end of the data.
+type drainReader struct {
+	io.Reader
+	io.Closer
+	d decrypter
+}
+
+func (r *drainReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		if _, derr := io.Copy(io.Discard, r.d); derr != nil {
+			return n, derr
+		}
+		if derr := r.d.finish(); derr != nil {
+			return n, derr
+		}
+	}
+	return n, err
+}
+
+// aesReader decrypts the data of an entry, and checks the authentication code
+// which follows it.
+type aesReader struct {
+	raw  io.Reader
+	data byteReader
+	ctr  cipher.Stream
+	mac  hash.Hash
+}
+
+func newAESReader(file *File, raw io.Reader, password string, size int64) (*aesReader, uint16, bool, error) {
+	var (
+		version, method uint16
+		strength        uint8
+	)
+	err := parseExtra(file.Extra, func(id uint16, field readBuf) error {
+		if id == aesExtraID && len(field) >= 7 {
+			version = field.uint16()
+			field.uint16() // vendor id
+			strength = field.uint8()
+			method = field.uint16()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if strength < 1 || strength > 3 {
+		return nil, 0, false, fmt.Errorf("%w: AES strength %d", ErrFormat, strength)
+	}
+
+	saltLen := 4 + 4*int(strength) // 8, 12 or 16 bytes
+	overhead := int64(saltLen + aesVerifierLen + aesAuthCodeLen)
+	if size >= 0 && size < overhead {
+		return nil, 0, false, ErrFormat
+	}
+
+	header := make([]byte, saltLen+aesVerifierLen)
+	if _, err = io.ReadFull(raw, header); err != nil {
+		return nil, 0, false, err
+	}
+	encKey, macKey, verifier := aesKeys(password, header[:saltLen])
+	if subtle.ConstantTimeCompare(verifier, header[saltLen:]) != 1 {
+		return nil, 0, false, ErrPassword
+	}
+
+	ctr, err := newAESCTR(encKey)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	n := int64(-1)
+	if size >= 0 {
+		n = size - overhead
+	}
+	return &aesReader{
+		raw:  raw,
+		data: dataReader(raw, n),
+		ctr:  ctr,
+		mac:  hmac.New(sha1.New, macKey),
+	}, method, version == aesVendorVersion1, nil
+}
+
+func (r *aesReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	r.mac.Write(p[:n])
+	r.ctr.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+func (r *aesReader) ReadByte() (byte, error) {
+	b, err := r.data.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	buf := [1]byte{b}
+	r.mac.Write(buf[:])
+	r.ctr.XORKeyStream(buf[:], buf[:])
+	return buf[0], nil
+}
+
+func (r *aesReader) finish() error {
+	var authCode [aesAuthCodeLen]byte
+	if _, err := io.ReadFull(r.raw, authCode[:]); err != nil {
+		return err
+	}
+	if !hmac.Equal(authCode[:], r.mac.Sum(nil)[:aesAuthCodeLen]) {
+		return ErrAuthentication
+	}
+	return nil
+}
+
+// Traditional PKWARE encryption.
+// reference: https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT
+// 6.1 Traditional PKWARE Encryption
+const zipCryptoHeaderLen = 12
+
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	k := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		k.update(password[i])
+	}
+	return k
+}
+
+func crc32Update(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ crc>>8
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = crc32Update(k[0], b)
+	k[1] = (k[1]+k[0]&0xff)*134775813 + 1
+	k[2] = crc32Update(k[2], byte(k[1]>>24))
+}
+
+func (k *zipCryptoKeys) decryptByte(c byte) byte {
+	t := k[2] | 2
+	b := c ^ byte(t*(t^1)>>8)
+	k.update(b)
+	return b
+}
+
+type zipCryptoReader struct {
+	data byteReader
+	keys *zipCryptoKeys
+}
+
+func newZipCryptoReader(file *File, raw io.Reader, password string, size int64) (*zipCryptoReader, error) {
+	if size >= 0 && size < zipCryptoHeaderLen {
+		return nil, ErrFormat
+	}
+	keys := newZipCryptoKeys(password)
+	var header [zipCryptoHeaderLen]byte
+	if _, err := io.ReadFull(raw, header[:]); err != nil {
+		return nil, err
+	}
+	for i, c := range header {
+		header[i] = keys.decryptByte(c)
+	}
+
+	// the last byte of the header is the high byte of the crc32, or of the
+	// modification time if the crc32 is in a data descriptor
+	check := byte(file.CRC32 >> 24)
+	if file.Flags&0x8 != 0 {
+		check = byte(file.ModifiedTime >> 8)
+	}
+	if header[zipCryptoHeaderLen-1] != check {
+		return nil, ErrPassword
+	}
+
+	n := int64(-1)
+	if size >= 0 {
+		n = size - zipCryptoHeaderLen
+	}
+	return &zipCryptoReader{data: dataReader(raw, n), keys: keys}, nil
+}
+
+func (r *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	for i, c := range p[:n] {
+		p[i] = r.keys.decryptByte(c)
+	}
+	return n, err
+}
+
+func (r *zipCryptoReader) ReadByte() (byte, error) {
+	c, err := r.data.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	return r.keys.decryptByte(c), nil
+}
+
+func (r *zipCryptoReader) finish() error {
+	return nil
+}
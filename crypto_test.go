@@ -0,0 +1,200 @@
+package pzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/flate"
+)
+
+func TestArchiveTo_Password(t *testing.T) {
+	files := map[string]string{
+		"small.txt": "hello",
+		"large.txt": strings.Repeat("hello world\n", 1000),
+	}
+	buf := new(bytes.Buffer)
+	err := ArchiveTo(context.Background(), buf, &ArchiveOptions{
+		Concurrency: 2,
+		Level:       -1,
+		Password:    "secret",
+		Entries: []Entry{
+			{Name: "small.txt", Size: 5, Mode: 0644, Open: openString(files["small.txt"])},
+			{Name: "large.txt", Size: -1, Mode: 0644, Reader: strings.NewReader(files["large.txt"])},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.File {
+		if f.Method != aesMethod || f.Flags&0x1 == 0 || f.CRC32 != 0 {
+			t.Fatalf("%s: got method %d, flags %#x, crc32 %#x, want AE-2", f.Name, f.Method, f.Flags, f.CRC32)
+		}
+		rc, err := f.OpenRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		salt, data := data[:aesSaltLen], data[aesSaltLen:]
+		verifier, data := data[:aesVerifierLen], data[aesVerifierLen:]
+		data, authCode := data[:len(data)-aesAuthCodeLen], data[len(data)-aesAuthCodeLen:]
+
+		encKey, macKey, wantVerifier := aesKeys("secret", salt)
+		if !bytes.Equal(verifier, wantVerifier) {
+			t.Fatalf("%s: password verifier mismatch", f.Name)
+		}
+		mac := hmac.New(sha1.New, macKey)
+		mac.Write(data)
+		if !bytes.Equal(authCode, mac.Sum(nil)[:aesAuthCodeLen]) {
+			t.Fatalf("%s: authentication code mismatch", f.Name)
+		}
+
+		ctr, err := newAESCTR(encKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctr.XORKeyStream(data, data)
+		var content []byte
+		switch method := ActualMethod(f.Method, f.Extra); method {
+		case zip.Store:
+			content = data
+		case zip.Deflate:
+			if content, err = io.ReadAll(flate.NewReader(bytes.NewReader(data))); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			t.Fatalf("%s: unexpected method %d", f.Name, method)
+		}
+		if string(content) != files[f.Name] {
+			t.Errorf("%s: got %q, want %q", f.Name, content, files[f.Name])
+		}
+	}
+}
+
+func TestExtractFrom_Password(t *testing.T) {
+	content := strings.Repeat("hello world\n", 1000)
+	aesZip := new(bytes.Buffer)
+	err := ArchiveTo(context.Background(), aesZip, &ArchiveOptions{
+		Concurrency: 2,
+		Level:       -1,
+		Password:    "secret",
+		Entries: []Entry{
+			{Name: "a.txt", Size: int64(len(content)), Mode: 0644, Open: openString(content)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// traditional PKWARE encryption of stored data
+	zipCryptoZip := new(bytes.Buffer)
+	zw := zip.NewWriter(zipCryptoZip)
+	crc := crc32.ChecksumIEEE([]byte(content))
+	data := zipCryptoEncrypt("secret", []byte(content), crc)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "a.txt",
+		Flags:              0x1,
+		Method:             zip.Store,
+		CRC32:              crc,
+		CompressedSize64:   uint64(len(data)),
+		UncompressedSize64: uint64(len(content)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write(data)
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, archive := range map[string][]byte{"aes": aesZip.Bytes(), "zipcrypto": zipCryptoZip.Bytes()} {
+		extract := func(opts *ExtractOptions) (*MemFS, error) {
+			mfs := NewMemFS()
+			opts.Concurrency = 1
+			opts.FS = mfs
+			return mfs, ExtractFrom(context.Background(), bytes.NewReader(archive), int64(len(archive)), opts)
+		}
+
+		mfs, err := extract(&ExtractOptions{Password: "secret"})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if b, _ := fs.ReadFile(mfs, "a.txt"); string(b) != content {
+			t.Errorf("%s: got %q", name, b)
+		}
+
+		mfs, err = extract(&ExtractOptions{Password: "wrong"})
+		if !errors.Is(err, ErrPassword) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrPassword)
+		}
+		if _, err = fs.Stat(mfs, "a.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: a.txt is created with a wrong password", name)
+		}
+
+		if _, err = extract(&ExtractOptions{}); !errors.Is(err, ErrPasswordRequired) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrPasswordRequired)
+		}
+
+		var prompted string
+		_, err = extract(&ExtractOptions{Prompt: func(name string) (string, error) {
+			prompted = name
+			return "secret", nil
+		}})
+		if err != nil || prompted != "a.txt" {
+			t.Errorf("%s: got %v, prompted for %q", name, err, prompted)
+		}
+	}
+
+	// flip a bit of the encrypted data, which is stored
+	stored := new(bytes.Buffer)
+	err = ArchiveTo(context.Background(), stored, &ArchiveOptions{
+		Concurrency: 1,
+		Password:    "secret",
+		Entries: []Entry{
+			{Name: "a.png", Size: int64(len(content)), Mode: 0644, Open: openString(content)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := stored.Bytes()
+	corrupted[len(corrupted)/2] ^= 1
+	err = ExtractFrom(context.Background(), bytes.NewReader(corrupted), int64(len(corrupted)), &ExtractOptions{
+		Concurrency: 1,
+		Password:    "secret",
+		FS:          NewMemFS(),
+	})
+	if !errors.Is(err, ErrAuthentication) {
+		t.Errorf("got %v, want %v", err, ErrAuthentication)
+	}
+}
+
+func zipCryptoEncrypt(password string, data []byte, crc uint32) []byte {
+	keys := newZipCryptoKeys(password)
+	header := make([]byte, zipCryptoHeaderLen)
+	header[zipCryptoHeaderLen-1] = byte(crc >> 24)
+	out := make([]byte, 0, len(header)+len(data))
+	for _, c := range append(header, data...) {
+		t := keys[2] | 2
+		out = append(out, c^byte(t*(t^1)>>8))
+		keys.update(c)
+	}
+	return out
+}
@@ -0,0 +1,122 @@
+package pzip
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// dedup groups regular files of identical content, which is compressed for the
+// first file only and copied for the others like hard links. The files are
+// walked and counted by size before they are archived, the files of the sizes
+// of more than one file are hashed before they are compressed.
+type dedup struct {
+	// sizes are the numbers of regular files of each size.
+	sizes map[int64]int
+
+	mu       sync.Mutex
+	contents map[contentKey]*hardLink
+}
+
+type contentKey struct {
+	size  uint64
+	crc32 uint32
+	sum   [sha256.Size]byte
+}
+
+func newDedup() *dedup {
+	return &dedup{
+		sizes:    make(map[int64]int),
+		contents: make(map[contentKey]*hardLink),
+	}
+}
+
+// walkedFile is a file walked before it is archived.
+type walkedFile struct {
+	absPath, name string
+	info          fs.FileInfo
+	entry         *Entry
+}
+
+// walk walks the files and entries of o, counting the regular files of each
+// size, and calls fn for them once they are counted. The content of entries
+// is not read until fn is called.
+func (d *dedup) walk(ctx context.Context, o *ArchiveOptions, fn walkFunc) (error, error) {
+	var files []walkedFile
+	err, submitErr := o.walk(func(absPath, name string, info fs.FileInfo, entry *Entry) (error, error) {
+		// stop walking once canceled
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if info.Mode().IsRegular() {
+			d.sizes[info.Size()]++
+		}
+		files = append(files, walkedFile{absPath: absPath, name: name, info: info, entry: entry})
+		return nil, nil
+	})
+	if err != nil || submitErr != nil {
+		return err, submitErr
+	}
+	for _, f := range files {
+		if err, submitErr = fn(f.absPath, f.name, f.info, f.entry); err != nil || submitErr != nil {
+			return err, submitErr
+		}
+	}
+	return nil, nil
+}
+
+// add marks obj to be hashed when it is compressed, if another file of its
+// size is counted. Only files that can be read again are deduplicated.
+func (d *dedup) add(obj *Object) {
+	size := obj.Info.Size()
+	if obj.hardLink != nil || !obj.Info.Mode().IsRegular() || !obj.reopen || obj.encrypted() || size <= 0 {
+		return
+	}
+	if d.sizes[size] > 1 {
+		obj.dedup = d
+	}
+}
+
+// match hashes the content of obj, and groups it with the first file of the
+// same content, obj is the first one if there is none.
+func (d *dedup) match(obj *Object) error {
+	src, err := obj.source()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	crc := crc32.NewIEEE()
+	h := sha256.New()
+	n, err := copyContext(obj.context(), io.MultiWriter(crc, h), src)
+	if err != nil {
+		return fmt.Errorf("hash %q: %w", obj.Path, err)
+	}
+	key := contentKey{size: uint64(n), crc32: crc.Sum32()}
+	h.Sum(key.sum[:0])
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if hl, ok := d.contents[key]; ok {
+		obj.hardLink, obj.linked = hl, true
+		return nil
+	}
+	// the number of other files is unknown, the content is kept until the end
+	hl := &hardLink{name: obj.header.Name, links: -1, dedup: true}
+	d.contents[key] = hl
+	obj.hardLink = hl
+	return nil
+}
+
+// groups returns the groups of files, after the workers stop.
+func (d *dedup) groups() []*hardLink {
+	groups := make([]*hardLink, 0, len(d.contents))
+	for _, hl := range d.contents {
+		groups = append(groups, hl)
+	}
+	return groups
+}
@@ -0,0 +1,89 @@
+package pzip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestArchiveTo_Dedup(t *testing.T) {
+	random := make([]byte, defaultBufSize+1024)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	license := strings.Repeat("Permission is hereby granted, free of charge\n", 20)
+	files := map[string]string{
+		"a/LICENSE":   license,
+		"b/LICENSE":   license,
+		"c/LICENSE":   license,
+		"d/LICENSE":   license,
+		"e/OTHER":     strings.Repeat("x", len(license)),
+		"r1.bin":      string(random),
+		"r2.bin":      string(random),
+		"r3.bin":      string(random),
+		"single.txt":  "hello",
+		"image.png":   strings.Repeat("png", 100),
+		"image2.png":  strings.Repeat("png", 100),
+		"image3.png":  strings.Repeat("png", 100),
+		"small/empty": "",
+		"pair/1.txt":  "a pair of identical files",
+		"pair/2.txt":  "a pair of identical files",
+	}
+	root := writeTestTree(t, files)
+	tempDir := t.TempDir()
+
+	buf := new(bytes.Buffer)
+	opts := &ArchiveOptions{
+		Files:       []string{root},
+		Recurse:     true,
+		Concurrency: 2,
+		TempDir:     tempDir,
+		Dedup:       true,
+		// the target is read once, when the entry is archived
+		Entries: []Entry{{Name: "link", Mode: fs.ModeSymlink | 0777, Reader: strings.NewReader("pair/1.txt")}},
+	}
+	if err := ArchiveTo(context.Background(), buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("temp dir not cleaned up: %d entries left", len(entries))
+	}
+
+	got := readTestArchive(t, buf.Bytes())
+	for name, content := range files {
+		key := HeaderName(root + "/" + name)
+		if got[key] != content {
+			t.Errorf("%s: got %d bytes, want %d", key, len(got[key]), len(content))
+		}
+	}
+	if got["link"] != "pair/1.txt" {
+		t.Errorf("link: got target %q", got["link"])
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.File {
+		if _, ok := FileHardLink(f); ok {
+			t.Errorf("%s: identical file is recorded as a hard link", f.Name)
+		}
+	}
+
+	// each content is compressed once, e.OTHER has the size of LICENSE
+	stats := opts.Stats()
+	wantSaved := uint64(3*len(license) + 2*len(random) + 2*300 + len(files["pair/1.txt"]))
+	if stats.Duplicates != 8 || stats.SavedBytes != wantSaved {
+		t.Errorf("got %+v, want 8 duplicates, %d bytes saved", stats, wantSaved)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts = &ArchiveOptions{Files: []string{root}, Recurse: true, Concurrency: 1, Dedup: true}
+	if err = ArchiveTo(ctx, io.Discard, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
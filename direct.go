@@ -0,0 +1,128 @@
+package pzip
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// errRegionStopped is returned by waits for regions stopped by a failed writer.
+var errRegionStopped = errors.New("region wait stopped")
+
+// directWriter creates the regions of the archive requested by the compress
+// workers, which write the data of their files into them with WriteAt instead
+// of overflow files copied by the writer.
+type directWriter struct {
+	// submit submits an object to the write worker, done is closed when it fails.
+	submit func(obj *Object) error
+	done   <-chan struct{}
+}
+
+// canWriteDirect reports whether the data of o can be written into a region.
+// The data of hard links and identical files is kept for the others, and that
+// of encrypted files ends with the authentication code in the header. Batched
+// files have no direct writer, the rest of their batch is written after them.
+func (o *Object) canWriteDirect() bool {
+	return o.direct != nil && o.reopen && !o.stream && o.link == "" && o.Info.Mode().IsRegular() &&
+		!o.encrypted() && o.hardLink == nil && o.dedup == nil && !o.linked
+}
+
+// requestRegion submits o to the writer for a region of size bytes, or an open
+// one if size is negative, and waits for it.
+func (o *Object) requestRegion(size int64) error {
+	if o.granted == nil {
+		o.granted = make(chan *Region, 1)
+		o.regionClosed = make(chan error, 1)
+	}
+	o.regionSize = size
+	o.regionWanted = true
+	if err := o.direct.submit(o); err != nil {
+		return err
+	}
+	select {
+	case o.region = <-o.granted:
+	case <-o.direct.done:
+	}
+	if o.region == nil {
+		return errRegionStopped
+	}
+	return nil
+}
+
+// createRegion creates the region requested by o in w. The writer waits for
+// the data of an open region, since the next file follows it.
+func (o *Object) createRegion(w *Writer) error {
+	o.regionWanted = false
+	region, err := w.CreateAt(o.header, o.regionSize)
+	if err != nil {
+		o.granted <- nil
+		return fmt.Errorf("create region for %q: %w", o.Path, err)
+	}
+	o.granted <- region
+	if o.regionSize >= 0 {
+		return nil
+	}
+	select {
+	case err = <-o.regionClosed:
+	case <-o.direct.done:
+		err = errRegionStopped
+	}
+	if err != nil {
+		return fmt.Errorf("region of %q is not complete", o.Path)
+	}
+	return nil
+}
+
+// storeDirect stores the data of o into a region of its size.
+func (o *Object) storeDirect() error {
+	fd, err := o.source()
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	size := int64(o.header.UncompressedSize64)
+	if err = o.requestRegion(size); err != nil {
+		return err
+	}
+	hash32 := crc32.NewIEEE()
+	// write exactly the size in the header even if the source changed
+	if _, err = copyNContext(o.context(), io.MultiWriter(io.NewOffsetWriter(o.region, 0), hash32), fd, size); err != nil {
+		return fmt.Errorf("store %q: %w", o.Path, err)
+	}
+	o.header.CompressedSize64 = uint64(size)
+	o.header.CRC32 = hash32.Sum32()
+	return o.region.Close()
+}
+
+// startRegion continues the compressed data of o, which does not fit in
+// compressedData, in an open region instead of an overflow file.
+func (o *Object) startRegion() error {
+	if err := o.requestRegion(-1); err != nil {
+		return err
+	}
+	n, err := o.region.WriteAt(o.compressedData.Bytes(), 0)
+	if err != nil {
+		return err
+	}
+	o.dst = io.NewOffsetWriter(o.region, int64(n))
+	return nil
+}
+
+// closeRegion closes the open region of o after the data is compressed with
+// err, and lets the writer continue.
+func (o *Object) closeRegion(err error) error {
+	if err == nil {
+		err = o.region.Close()
+	}
+	o.regionClosed <- err
+	return err
+}
+
+// directStore reports whether o is stored into a region, which is the case
+// for those larger than the buffer, smaller ones are read twice from the cache.
+func (o *Object) directStore() bool {
+	return o.writeDirect && o.header.Method == zip.Store && o.header.UncompressedSize64 > uint64(o.compressedData.Cap())
+}
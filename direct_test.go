@@ -0,0 +1,106 @@
+package pzip
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchive_DirectWrite(t *testing.T) {
+	random := make([]byte, 256<<10)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	files := map[string]string{
+		"random.bin": string(random),
+		"photo.jpg":  string(random[:100<<10]),
+		"text.txt":   strings.Repeat("hello world\n", 50000),
+		"small.txt":  strings.Repeat("small\n", 100),
+		"empty.txt":  "",
+	}
+	for i := 0; i < 8; i++ {
+		files["text"+string(rune('a'+i))+".log"] = string(random[i<<10 : i<<15])
+	}
+	dir := writeTestTree(t, files)
+
+	for _, budget := range []int64{0, 64 << 10} {
+		path := filepath.Join(t.TempDir(), "test.zip")
+		opts := &ArchiveOptions{
+			Files:       []string{dir},
+			Recurse:     true,
+			Concurrency: 4,
+			BufferSize:  16 << 10,
+			MaxMemory:   budget,
+			TempDir:     t.TempDir(),
+			DirectWrite: true,
+			Rules:       []Rule{{Suffix: ".log", Method: DeflateMethod}},
+		}
+		if err := Archive(context.Background(), path, opts); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(opts.TempDir)
+		if err != nil || len(entries) > 0 {
+			t.Errorf("got %d overflow files, %v", len(entries), err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the local headers are complete for stream readers
+		mfs := NewMemFS()
+		if err = ExtractStream(context.Background(), bytes.NewReader(data), &ExtractOptions{Concurrency: 1, FS: mfs}); err != nil {
+			t.Fatal(err)
+		}
+		checkMemFS(t, mfs, dir, files)
+	}
+
+	opts := &ArchiveOptions{Files: []string{dir}, Concurrency: 1, DirectWrite: true}
+	if err := ArchiveTo(context.Background(), new(bytes.Buffer), opts); err == nil {
+		t.Error("got no error of direct writes to a writer")
+	}
+}
+
+func TestArchive_DirectWriteBatch(t *testing.T) {
+	// the entries grow after they are batched
+	random := make([]byte, 256<<10)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	var entries []Entry
+	want := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		name := fmt.Sprintf("f%02d.txt", i)
+		data := random[i<<10 : 100+i<<13]
+		entries = append(entries, Entry{
+			Name:     name,
+			Size:     100,
+			Mode:     0644,
+			Modified: time.Now(),
+			Open: func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(data)), nil
+			},
+		})
+		want[name] = string(data)
+	}
+	path := filepath.Join(t.TempDir(), "test.zip")
+	opts := &ArchiveOptions{
+		Entries:     entries,
+		Concurrency: 4,
+		BufferSize:  16 << 10,
+		BatchSize:   3,
+		DirectWrite: true,
+		Rules:       []Rule{{Suffix: ".txt", Method: DeflateMethod}},
+	}
+	if err := Archive(context.Background(), path, opts); err != nil {
+		t.Fatal(err)
+	}
+	mfs := NewMemFS()
+	if err := Extract(context.Background(), path, &ExtractOptions{Concurrency: 1, FS: mfs}); err != nil {
+		t.Fatal(err)
+	}
+	checkMemFS(t, mfs, "", want)
+}
@@ -0,0 +1,270 @@
+package pzip
+
+import (
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	textunicode "golang.org/x/text/encoding/unicode"
+)
+
+// encodings are the names of common legacy encodings, without case and separators.
+var encodings = map[string]encoding.Encoding{
+	"utf8":       textunicode.UTF8,
+	"gbk":        simplifiedchinese.GBK,
+	"cp936":      simplifiedchinese.GBK,
+	"gb2312":     simplifiedchinese.GBK,
+	"gb18030":    simplifiedchinese.GB18030,
+	"big5":       traditionalchinese.Big5,
+	"cp950":      traditionalchinese.Big5,
+	"shiftjis":   japanese.ShiftJIS,
+	"sjis":       japanese.ShiftJIS,
+	"cp932":      japanese.ShiftJIS,
+	"windows31j": japanese.ShiftJIS,
+	"eucjp":      japanese.EUCJP,
+	"euckr":      korean.EUCKR,
+	"cp949":      korean.EUCKR,
+	"cp437":      charmap.CodePage437,
+	"ibm437":     charmap.CodePage437,
+	"cp850":      charmap.CodePage850,
+	"ibm850":     charmap.CodePage850,
+	"cp866":      charmap.CodePage866,
+	"ibm866":     charmap.CodePage866,
+}
+
+// detectEncodings are the candidates of DetectEncoding, in order of preference.
+var detectEncodings = []encoding.Encoding{
+	simplifiedchinese.GBK,
+	japanese.ShiftJIS,
+	traditionalchinese.Big5,
+	korean.EUCKR,
+	charmap.CodePage437,
+}
+
+// LookupEncoding returns the encoding of name, such as GBK, Shift-JIS or CP437.
+// Case and separators of name are ignored, other IANA names are supported as well.
+func LookupEncoding(name string) (encoding.Encoding, error) {
+	key := strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == ' ' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+	if enc, ok := encodings[key]; ok {
+		return enc, nil
+	}
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+	return enc, nil
+}
+
+// DetectEncoding returns the legacy encoding most likely to have produced names,
+// which are not valid UTF-8, or nil if none decodes all of them.
+func DetectEncoding(names ...string) encoding.Encoding {
+	var (
+		best      encoding.Encoding
+		bestScore int
+	)
+	for _, enc := range detectEncodings {
+		score, ok := encodingScore(enc, names)
+		if ok && (best == nil || score > bestScore) {
+			best, bestScore = enc, score
+		}
+	}
+	return best
+}
+
+// encodingScore reports whether enc decodes names without errors, and how
+// likely the decoded text is, by counting the characters of common scripts.
+func encodingScore(enc encoding.Encoding, names []string) (score int, ok bool) {
+	dec := enc.NewDecoder()
+	// common Han characters are those in GB2312, which most characters
+	// of Japanese and Korean names are in as well
+	gb2312 := simplifiedchinese.HZGB2312.NewEncoder()
+	for _, name := range names {
+		s, err := dec.String(name)
+		if err != nil {
+			return 0, false
+		}
+		for _, r := range s {
+			switch {
+			case r == utf8.RuneError || unicode.IsControl(r) || unicode.Is(unicode.Co, r):
+				return 0, false
+			case r < utf8.RuneSelf:
+			case r >= 0xff61 && r <= 0xff9f:
+				// halfwidth katakana are rare, but Shift-JIS decodes many bytes to them
+				score--
+			case unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+				score += 2
+			case unicode.Is(unicode.Han, r):
+				if _, err := gb2312.String(string(r)); err == nil {
+					score += 2
+				}
+			case r >= 0x3000 && r <= 0x303f, r >= 0xff01 && r <= 0xff5e:
+				// CJK punctuation and fullwidth forms
+				score++
+			default:
+				score--
+			}
+		}
+	}
+	return score, true
+}
+
+// DecodeNames decodes the names and comments of files, preferring the Info-ZIP
+// Unicode extra fields whose CRC-32 matches. Those without the fields or the
+// UTF-8 flag are decoded with enc. If enc is nil, those that are not valid
+// UTF-8 are decoded with the encoding detected from all of them.
+func DecodeNames(files []*File, enc encoding.Encoding) {
+	var (
+		legacy  []*File
+		comment []bool // whether the comment is legacy too
+	)
+	for _, f := range files {
+		unicodeName, unicodeComment := decodeUnicodeExtra(f)
+		if unicodeName || f.Flags&0x800 != 0 {
+			continue
+		}
+		if enc != nil || !utf8.ValidString(f.Name) || !unicodeComment && !utf8.ValidString(f.Comment) {
+			legacy = append(legacy, f)
+			comment = append(comment, !unicodeComment)
+		}
+	}
+	if len(legacy) == 0 {
+		return
+	}
+
+	if enc == nil {
+		names := make([]string, 0, 2*len(legacy))
+		for i, f := range legacy {
+			names = append(names, f.Name)
+			if comment[i] {
+				names = append(names, f.Comment)
+			}
+		}
+		if enc = DetectEncoding(names...); enc == nil {
+			return
+		}
+	}
+	for i, f := range legacy {
+		decodeLegacy(f, enc, comment[i])
+	}
+}
+
+// decodeName is DecodeNames of a single file, the encoding is detected from
+// its own name if enc is nil.
+func decodeName(f *File, enc encoding.Encoding) {
+	unicodeName, unicodeComment := decodeUnicodeExtra(f)
+	if unicodeName || f.Flags&0x800 != 0 {
+		return
+	}
+	if enc == nil {
+		if utf8.ValidString(f.Name) && (unicodeComment || utf8.ValidString(f.Comment)) {
+			return
+		}
+		names := []string{f.Name}
+		if !unicodeComment {
+			names = append(names, f.Comment)
+		}
+		if enc = DetectEncoding(names...); enc == nil {
+			return
+		}
+	}
+	decodeLegacy(f, enc, !unicodeComment)
+}
+
+// decodeLegacy decodes the name of f, and the comment if comment is true, with enc.
+func decodeLegacy(f *File, enc encoding.Encoding, comment bool) {
+	dec := enc.NewDecoder()
+	if name, err := dec.String(f.Name); err == nil && safeName(f.Name, name) {
+		f.Name = name
+	}
+	if !comment {
+		return
+	}
+	if c, err := dec.String(f.Comment); err == nil {
+		f.Comment = c
+	}
+}
+
+// decodeUnicodeExtra sets the name and comment of f from the Info-ZIP Unicode
+// Path and Comment extra fields whose CRC-32 matches the legacy value.
+func decodeUnicodeExtra(f *File) (name, comment bool) {
+	_ = parseExtra(f.Extra, func(id uint16, field readBuf) error {
+		if id != unicodePathExtraID && id != unicodeCommentExtraID || len(field) < 5 {
+			return nil
+		}
+		// version 1, CRC-32 of the legacy value, UTF-8 value
+		if field.uint8() != 1 {
+			return nil
+		}
+		crc := field.uint32()
+		value := string(field)
+		if !utf8.ValidString(value) {
+			return nil
+		}
+		switch {
+		case id == unicodePathExtraID && !name && crc == crc32.ChecksumIEEE([]byte(f.Name)) && safeName(f.Name, value):
+			f.Name, name = value, true
+		case id == unicodeCommentExtraID && !comment && crc == crc32.ChecksumIEEE([]byte(f.Comment)):
+			f.Comment, comment = value, true
+		}
+		return nil
+	})
+	return name, comment
+}
+
+// safeName reports whether the decoded name does not escape where the raw one does not.
+func safeName(raw, decoded string) bool {
+	return !localName(raw) || localName(decoded)
+}
+
+func localName(name string) bool {
+	return name == "" || filepath.IsLocal(filepath.FromSlash(strings.TrimSuffix(name, "/")))
+}
+
+// encodeName returns name and comment encoded by e, ok is false if they are
+// ASCII or can not be encoded.
+func encodeName(e *encoding.Encoder, name, comment string) (encName, encComment string, ok bool) {
+	_, requireName := detectUTF8(name)
+	_, requireComment := detectUTF8(comment)
+	if !requireName && !requireComment {
+		return name, comment, false
+	}
+	var err error
+	if encName, err = e.String(name); err != nil {
+		return name, comment, false
+	}
+	if encComment, err = e.String(comment); err != nil {
+		return name, comment, false
+	}
+	return encName, encComment, true
+}
+
+// unicodeExtra returns the Info-ZIP Unicode Path or Comment extra field id of
+// the UTF-8 value, with the CRC-32 of the legacy value.
+func unicodeExtra(id uint16, legacy, value string) []byte {
+	if len(value) > uint16max-5 {
+		return nil
+	}
+	buf := make([]byte, 9+len(value))
+	b := writeBuf(buf)
+	b.uint16(id)
+	b.uint16(uint16(5 + len(value)))
+	b.uint8(1) // version
+	b.uint32(crc32.ChecksumIEEE([]byte(legacy)))
+	copy(b, value)
+	return buf
+}
@@ -0,0 +1,177 @@
+package pzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/fs"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		enc   encoding.Encoding
+		names []string
+	}{
+		{simplifiedchinese.GBK, []string{"中文/", "中文/新建文本文档.txt"}},
+		{simplifiedchinese.GBK, []string{"报告.docx"}},
+		{japanese.ShiftJIS, []string{"テスト.txt", "日本語のファイル.txt"}},
+		{charmap.CodePage437, []string{"café/résumé.txt"}},
+	} {
+		names := make([]string, 0, len(tt.names))
+		for _, name := range tt.names {
+			encoded, err := tt.enc.NewEncoder().String(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, encoded)
+		}
+		if got := DetectEncoding(names...); got != tt.enc {
+			t.Errorf("%q: got %v, want %v", tt.names, got, tt.enc)
+		}
+	}
+}
+
+func TestLookupEncoding(t *testing.T) {
+	for name, want := range map[string]encoding.Encoding{
+		"GBK":        simplifiedchinese.GBK,
+		"Shift-JIS":  japanese.ShiftJIS,
+		"shift_jis":  japanese.ShiftJIS,
+		"CP437":      charmap.CodePage437,
+		"ISO-8859-1": charmap.ISO8859_1,
+	} {
+		if got, err := LookupEncoding(name); err != nil || got != want {
+			t.Errorf("%s: got %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := LookupEncoding("unknown"); err == nil {
+		t.Error("unknown: got nil error")
+	}
+}
+
+func TestArchiveTo_NameEncoding(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := ArchiveTo(context.Background(), buf, &ArchiveOptions{
+		Concurrency:  1,
+		NameEncoding: simplifiedchinese.GBK,
+		Entries: []Entry{
+			{Name: "中文/", Mode: fs.ModeDir | 0755},
+			{Name: "中文/文档.txt", Size: 5, Mode: 0644, Open: openString("hello")},
+			{Name: "emoji-😀.txt", Size: 5, Mode: 0644, Open: openString("hello")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gbkName, _ := simplifiedchinese.GBK.NewEncoder().String("中文/文档.txt")
+	for i, want := range []struct {
+		name string
+		utf8 bool
+	}{
+		{"\xd6\xd0\xce\xc4/", false},
+		{gbkName, false},
+		{"emoji-😀.txt", true}, // GBK can not encode it
+	} {
+		f := r.File[i]
+		if f.Name != want.name || (f.Flags&0x800 != 0) != want.utf8 {
+			t.Errorf("got %q, flags %#x, want %q, UTF-8 %v", f.Name, f.Flags, want.name, want.utf8)
+		}
+	}
+
+	for _, enc := range []encoding.Encoding{nil, simplifiedchinese.GBK} {
+		for _, stream := range []bool{false, true} {
+			mfs := NewMemFS()
+			opts := &ExtractOptions{Concurrency: 1, FS: mfs, NameEncoding: enc}
+			if stream {
+				err = ExtractStream(context.Background(), bytes.NewReader(buf.Bytes()), opts)
+			} else {
+				err = ExtractFrom(context.Background(), bytes.NewReader(buf.Bytes()), int64(buf.Len()), opts)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"中文/文档.txt", "emoji-😀.txt"} {
+				if b, err := fs.ReadFile(mfs, name); err != nil || string(b) != "hello" {
+					t.Errorf("encoding %v, stream %v: %s: got %q, %v", enc, stream, name, b, err)
+				}
+			}
+		}
+	}
+}
+
+func TestArchiveTo_UnicodeExtra(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := ArchiveTo(context.Background(), buf, &ArchiveOptions{
+		Concurrency:  1,
+		UnicodeExtra: true,
+		Entries: []Entry{
+			{Name: "中文/", Mode: fs.ModeDir | 0755},
+			{Name: "中文/文档.txt", Size: 5, Mode: 0644, Open: openString("hello")},
+			{Name: "café.txt", Size: 5, Mode: 0644, Open: openString("hello")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"\x1a\x1a/", "\x1a\x1a/\x1a\x1a.txt", "caf\x82.txt"} {
+		f := r.File[i]
+		if f.Name != want || f.Flags&0x800 != 0 {
+			t.Errorf("got %q, flags %#x, want %q without the UTF-8 flag", f.Name, f.Flags, want)
+		}
+	}
+
+	// the Unicode Path field of another name is ignored
+	mismatch := new(bytes.Buffer)
+	zw := zip.NewWriter(mismatch)
+	_, err = zw.CreateHeader(&zip.FileHeader{
+		Name:  "old.txt",
+		Extra: unicodeExtra(unicodePathExtraID, "older.txt", "new.txt"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		archive []byte
+		names   []string
+	}{
+		{buf.Bytes(), []string{"中文/文档.txt", "café.txt"}},
+		{mismatch.Bytes(), []string{"old.txt"}},
+	} {
+		for _, stream := range []bool{false, true} {
+			mfs := NewMemFS()
+			opts := &ExtractOptions{Concurrency: 1, FS: mfs}
+			if stream {
+				err = ExtractStream(context.Background(), bytes.NewReader(tt.archive), opts)
+			} else {
+				err = ExtractFrom(context.Background(), bytes.NewReader(tt.archive), int64(len(tt.archive)), opts)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.names {
+				if _, err := fs.Stat(mfs, name); err != nil {
+					t.Errorf("stream %v: %v", stream, err)
+				}
+			}
+		}
+	}
+}
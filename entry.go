@@ -0,0 +1,87 @@
+package pzip
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+)
+
+// Entry is an item to archive that does not have to exist on the OS filesystem,
+// such as generated reports or database exports.
+type Entry struct {
+	// Name is the slash-separated path of the entry in the archive.
+	Name string
+	// Size is the length of the content, it decides whether the entry is
+	// stored or deflated. A negative Size means the length is unknown, such
+	// entries are deflated into the archive followed by a data descriptor.
+	Size     int64
+	Mode     fs.FileMode
+	Modified time.Time
+
+	// Open returns the content of the entry, it may be called more than once.
+	Open func() (io.ReadCloser, error)
+	// Reader is the content of the entry if Open is nil, it is read only once.
+	Reader io.Reader
+}
+
+// FileEntry returns an Entry for the file at name in fsys.
+func FileEntry(fsys fs.FS, name string, info fs.FileInfo) *Entry {
+	return &Entry{
+		Name:     name,
+		Size:     info.Size(),
+		Mode:     info.Mode(),
+		Modified: info.ModTime(),
+		Open: func() (io.ReadCloser, error) {
+			return fsys.Open(name)
+		},
+	}
+}
+
+func (e *Entry) info() fs.FileInfo {
+	return &entryInfo{entry: e}
+}
+
+// opener returns the function reading the content of the entry,
+// and reports whether it can be called more than once.
+func (e *Entry) opener() (func() (io.ReadCloser, error), bool) {
+	if e.Open != nil {
+		return e.Open, true
+	}
+	if e.Reader == nil {
+		return nil, false
+	}
+	read := false
+	return func() (io.ReadCloser, error) {
+		if read {
+			return nil, fmt.Errorf("entry %q can only be read once", e.Name)
+		}
+		read = true
+		return io.NopCloser(e.Reader), nil
+	}, false
+}
+
+type entryInfo struct {
+	entry *Entry
+}
+
+func (i *entryInfo) Name() string       { return path.Base(i.entry.Name) }
+func (i *entryInfo) Size() int64        { return i.entry.Size }
+func (i *entryInfo) Mode() fs.FileMode  { return i.entry.Mode }
+func (i *entryInfo) ModTime() time.Time { return i.entry.Modified }
+func (i *entryInfo) IsDir() bool        { return i.entry.Mode.IsDir() }
+func (i *entryInfo) Sys() any           { return nil }
+
+func readAll(open func() (io.ReadCloser, error)) ([]byte, error) {
+	if open == nil {
+		return nil, errors.New("no content")
+	}
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
@@ -0,0 +1,15 @@
+package pzip
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// adviseSequential advises the kernel that f is read sequentially, and to
+// prefetch its first n bytes.
+func adviseSequential(f *os.File, n int64) {
+	fd := int(f.Fd())
+	_ = unix.Fadvise(fd, 0, 0, unix.FADV_SEQUENTIAL)
+	_ = unix.Fadvise(fd, 0, n, unix.FADV_WILLNEED)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package pzip
+
+import "os"
+
+// adviseSequential does nothing, reads are not advised on this system.
+func adviseSequential(f *os.File, n int64) {}
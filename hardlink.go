@@ -0,0 +1,322 @@
+package pzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// hardLinkExtraID is the extra field of pzip with the name of the first archived
+// file that a file is a hard link of, which has the same content. The field is:
+//
+//	version  uint8, 1
+//	name     the UTF-8 name of the first file, to the end of the field
+const hardLinkExtraID = 0x6c70
+
+// maxHardLinkMemory is the most compressed data of hard linked files kept in
+// memory to be copied for their other names, the rest is kept in a temporary file.
+const maxHardLinkMemory = 64 << 20
+
+// hardLinkExtra returns the hard link extra field of name.
+func hardLinkExtra(name string) []byte {
+	if len(name) > uint16max-1 {
+		return nil
+	}
+	extra := make([]byte, 0, 5+len(name))
+	extra = binary.LittleEndian.AppendUint16(extra, hardLinkExtraID)
+	extra = binary.LittleEndian.AppendUint16(extra, uint16(1+len(name)))
+	extra = append(extra, 1) // version
+	return append(extra, name...)
+}
+
+// FileHardLink returns the name of the file that f is a hard link of, recorded
+// in the extra fields of f.
+func FileHardLink(f *File) (name string, ok bool) {
+	_ = parseExtra(f.Extra, func(id uint16, field readBuf) error {
+		if id != hardLinkExtraID || len(field) < 2 || field.uint8() != 1 || !utf8.Valid(field) {
+			return nil
+		}
+		name, ok = string(field), true
+		return nil
+	})
+	return name, ok
+}
+
+// fileID identifies a file of the OS filesystem.
+type fileID struct {
+	dev, ino uint64
+}
+
+// hardLink is a regular file archived with several names, its content is
+// compressed for the first one only, and copied for the others.
+type hardLink struct {
+	// name is the name of the first object, recorded in the extra field of the others.
+	name string
+	// dedup reports whether the files are not hard links but have identical
+	// content, which is not recorded.
+	dedup bool
+	// links is the number of the other names of the file, added counts those
+	// added by the walk, written those archived by the write worker.
+	links   int
+	added   int
+	written int
+	// content is the archived content of the first object, nil until it is archived.
+	content *linkContent
+	// pending are the other names received before the first one is archived.
+	pending []*Object
+}
+
+// linkContent is the archived content of the first name of a hard linked file.
+type linkContent struct {
+	method         uint16
+	crc32          uint32
+	compressedSize uint64
+	size           uint64
+
+	// open reads stored content from the source again.
+	open func() (io.ReadCloser, error)
+	// data is the compressed data in memory, or dataLen bytes at offset of spool.
+	data    []byte
+	spool   *os.File
+	offset  int64
+	dataLen int64
+	// overflow is the temporary file with the rest of the compressed data.
+	overflow string
+}
+
+// writeTo writes the compressed data to w, until ctx is done.
+func (c *linkContent) writeTo(ctx context.Context, w io.Writer) error {
+	if c.open != nil {
+		fd, err := c.open()
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		_, err = copyNContext(ctx, w, fd, int64(c.compressedSize))
+		return err
+	}
+
+	if c.spool != nil {
+		if _, err := copyContext(ctx, w, io.NewSectionReader(c.spool, c.offset, c.dataLen)); err != nil {
+			return err
+		}
+	} else if _, err := w.Write(c.data); err != nil {
+		return err
+	}
+	if c.overflow == "" {
+		return nil
+	}
+	f, err := os.Open(c.overflow)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = copyContext(ctx, w, f)
+	return err
+}
+
+// hardLinks groups the objects of regular files by their file IDs. add and
+// walkDone are called by the walk, write and release by the write worker.
+type hardLinks struct {
+	files map[fileID]*hardLink
+	all   []*hardLink
+	// walked is set once the walk ends, swept once the write worker freed the
+	// content of the files whose names are all written then.
+	walked atomic.Bool
+	swept  bool
+	// dedup has the groups of identical files if not nil.
+	dedup *dedup
+	stats *ArchiveStats
+	// dir is where the spool is created, temps records it.
+	dir       string
+	temps     *tempFiles
+	spool     *os.File
+	spoolSize int64
+	memory    int
+}
+
+// add groups obj with the objects of the same file added before.
+func (l *hardLinks) add(obj *Object) {
+	// each encrypted entry has its own salt
+	if !obj.Info.Mode().IsRegular() || obj.encrypted() {
+		return
+	}
+	id, nlink, ok := fileIdentity(obj.Info)
+	if !ok || nlink < 2 {
+		return
+	}
+	if l.files == nil {
+		l.files = make(map[fileID]*hardLink)
+	}
+	hl, ok := l.files[id]
+	// the file got more links since it was first added
+	if !ok || hl.added == hl.links {
+		hl = &hardLink{name: obj.header.Name, links: int(nlink) - 1}
+		l.files[id] = hl
+		l.all = append(l.all, hl)
+	} else {
+		hl.added++
+		obj.linked = true
+	}
+	obj.hardLink = hl
+}
+
+// walkDone is called once the walk ends, no other names are added after.
+func (l *hardLinks) walkDone() {
+	l.walked.Store(true)
+}
+
+// written reports whether hl is written for all its names, its links outside
+// the files archived are not walked.
+func (l *hardLinks) written(hl *hardLink) bool {
+	return hl.written == hl.links || !hl.dedup && l.walked.Load() && hl.written == hl.added
+}
+
+// sweep frees the content of the files written for all their names once the
+// walk ends.
+func (l *hardLinks) sweep() {
+	if l.swept || !l.walked.Load() {
+		return
+	}
+	l.swept = true
+	for _, hl := range l.all {
+		if hl.content != nil && l.written(hl) {
+			l.free(hl.content)
+		}
+	}
+}
+
+// write archives obj with write, the other names of a file are held until its
+// first one is archived. release is called with the objects once archived.
+func (l *hardLinks) write(obj *Object, write func(*Object) error, release func(*Object)) error {
+	l.sweep()
+	hl := obj.hardLink
+	if !obj.linked {
+		defer release(obj)
+		if err := write(obj); err != nil {
+			return err
+		}
+		// no other names are archived
+		if l.written(hl) {
+			return nil
+		}
+		content, err := l.keep(obj)
+		if err != nil {
+			return fmt.Errorf("keep content of %q for its hard links: %w", obj.Path, err)
+		}
+		hl.content = content
+		pending := hl.pending
+		hl.pending = nil
+		for i, link := range pending {
+			if err = l.write(link, write, release); err != nil {
+				hl.pending = pending[i+1:]
+				return err
+			}
+		}
+		return nil
+	}
+
+	if hl.content == nil {
+		hl.pending = append(hl.pending, obj)
+		return nil
+	}
+	defer release(obj)
+	c := hl.content
+	obj.header.Method = c.method
+	obj.header.CRC32 = c.crc32
+	obj.header.CompressedSize64 = c.compressedSize
+	obj.header.UncompressedSize64 = c.size
+	obj.linkContent = c
+	if err := write(obj); err != nil {
+		return err
+	}
+	l.stats.Duplicates++
+	l.stats.SavedBytes += c.size
+	if hl.written++; l.written(hl) {
+		l.free(c)
+	}
+	return nil
+}
+
+// keep returns the archived content of obj, taking its overflow file.
+func (l *hardLinks) keep(obj *Object) (*linkContent, error) {
+	c := &linkContent{
+		method:         obj.header.Method,
+		crc32:          obj.header.CRC32,
+		compressedSize: obj.header.CompressedSize64,
+		size:           obj.header.UncompressedSize64,
+	}
+	if obj.header.Method == zip.Store && !obj.buffered {
+		c.open = obj.open
+		return c, nil
+	}
+
+	data := obj.compressedData.Bytes()
+	if l.memory+len(data) <= maxHardLinkMemory {
+		c.data = bytes.Clone(data)
+		l.memory += len(data)
+	} else {
+		if l.spool == nil {
+			spool, err := l.temps.create(l.dir)
+			if err != nil {
+				return nil, fmt.Errorf("create temporary file: %w", err)
+			}
+			l.spool = spool
+		}
+		n, err := l.spool.Write(data)
+		if err != nil {
+			return nil, err
+		}
+		c.spool, c.offset, c.dataLen = l.spool, l.spoolSize, int64(n)
+		l.spoolSize += int64(n)
+	}
+
+	if obj.Overflowed() {
+		c.overflow = obj.overflow.Name()
+		err := obj.overflow.Close()
+		obj.overflow = nil
+		if err != nil {
+			_ = os.Remove(c.overflow)
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// free releases the memory and the overflow file of c.
+func (l *hardLinks) free(c *linkContent) {
+	l.memory -= len(c.data)
+	c.data = nil
+	if c.overflow != "" {
+		_ = os.Remove(c.overflow)
+		c.overflow = ""
+	}
+}
+
+// release releases what is still held, after the write worker stops.
+func (l *hardLinks) release(release func(*Object)) {
+	groups := l.all
+	if l.dedup != nil {
+		groups = append(groups, l.dedup.groups()...)
+	}
+	for _, hl := range groups {
+		if hl.content != nil {
+			l.free(hl.content)
+		}
+		for _, obj := range hl.pending {
+			release(obj)
+		}
+		hl.pending = nil
+	}
+	if l.spool != nil {
+		_ = l.spool.Close()
+		_ = os.Remove(l.spool.Name())
+		l.spool = nil
+	}
+}
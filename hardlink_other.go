@@ -0,0 +1,10 @@
+//go:build !unix
+
+package pzip
+
+import "io/fs"
+
+// fileIdentity returns false, hard links are not detected.
+func fileIdentity(info fs.FileInfo) (id fileID, nlink uint64, ok bool) {
+	return fileID{}, 0, false
+}
@@ -0,0 +1,201 @@
+package pzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestArchiveTo_HardLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard links are not detected")
+	}
+	random := make([]byte, defaultBufSize+1024)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	root := writeTestTree(t, map[string]string{
+		"a.txt":      strings.Repeat("hello world\n", 1000),
+		"random.bin": string(random),
+		"image.png":  strings.Repeat("png", 100),
+		"single.txt": "linked from outside",
+	})
+	links := map[string]string{
+		"b.txt":           "a.txt",
+		"dir/c.txt":       "a.txt",
+		"dir/random2.bin": "random.bin",
+		"image2.png":      "image.png",
+	}
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, first := range links {
+		if err := os.Link(filepath.Join(root, first), filepath.Join(root, name)); err != nil {
+			t.Skipf("hard links are not supported: %v", err)
+		}
+	}
+	if err := os.Link(filepath.Join(root, "single.txt"), filepath.Join(t.TempDir(), "outside.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	tempDir := t.TempDir()
+	buf := new(bytes.Buffer)
+	err := ArchiveTo(context.Background(), buf, &ArchiveOptions{
+		Files:       []string{root},
+		Recurse:     true,
+		Concurrency: 2,
+		TempDir:     tempDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("temp dir not cleaned up: %d entries left", len(entries))
+	}
+
+	got := readTestArchive(t, buf.Bytes())
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	firsts := make(map[string]string)
+	for _, f := range r.File {
+		if name, ok := FileHardLink(f); ok {
+			if got[f.Name] != got[name] {
+				t.Errorf("%s: content differs from %s", f.Name, name)
+			}
+			firsts[strings.TrimPrefix(f.Name, HeaderName(root)+"/")] = strings.TrimPrefix(name, HeaderName(root)+"/")
+		}
+	}
+	// the walk is in lexical order, image.png is before image2.png
+	if len(firsts) != len(links) {
+		t.Errorf("got hard links %v, want %v", firsts, links)
+	}
+
+	for _, stream := range []bool{false, true} {
+		out := t.TempDir()
+		opts := &ExtractOptions{Concurrency: 2, OutDir: out, HardLinks: true}
+		if stream {
+			err = ExtractStream(context.Background(), bytes.NewReader(buf.Bytes()), opts)
+		} else {
+			err = ExtractFrom(context.Background(), bytes.NewReader(buf.Bytes()), int64(buf.Len()), opts)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		base := filepath.Join(out, HeaderName(root))
+		for name, first := range firsts {
+			info, err := os.Stat(filepath.Join(base, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			firstInfo, err := os.Stat(filepath.Join(base, first))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !os.SameFile(info, firstInfo) {
+				t.Errorf("stream %v: %s is not a hard link of %s", stream, name, first)
+			}
+		}
+	}
+}
+
+func TestExtractFrom_HardLinkFallback(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, h := range []*zip.FileHeader{
+		{Name: "a.txt", Method: zip.Store},
+		{Name: "b.txt", Method: zip.Store, Extra: hardLinkExtra("a.txt")},
+		{Name: "c.txt", Method: zip.Store, Extra: hardLinkExtra("missing.txt")},
+	} {
+		h.SetMode(0644)
+		fw, err := w.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = fw.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mfs := NewMemFS()
+	opts := &ExtractOptions{Concurrency: 2, FS: mfs, HardLinks: true}
+	if err := ExtractFrom(context.Background(), bytes.NewReader(buf.Bytes()), int64(buf.Len()), opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if got := string(mfs.files[name].data); got != "hello" {
+			t.Errorf("%s: got %q, want %q", name, got, "hello")
+		}
+	}
+	if _, ok := mfs.files["missing.txt"]; ok {
+		t.Error("missing.txt is created")
+	}
+}
+
+func TestHardLinks_WalkDone(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard links are not detected")
+	}
+	root := writeTestTree(t, map[string]string{
+		"a.txt": strings.Repeat("linked from outside\n", 1000),
+		"b.txt": strings.Repeat("linked from outside too\n", 1000),
+	})
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.Link(filepath.Join(root, name), filepath.Join(t.TempDir(), name)); err != nil {
+			t.Skipf("hard links are not supported: %v", err)
+		}
+	}
+
+	l := &hardLinks{dir: t.TempDir(), stats: new(ArchiveStats)}
+	pool := NewObjectPoolSize(64 << 10)
+	add := func(name string) *Object {
+		path := filepath.Join(root, name)
+		info, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err := pool.New(path, info, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = obj.Compress(); err != nil {
+			t.Fatal(err)
+		}
+		l.add(obj)
+		return obj
+	}
+	write := func(*Object) error { return nil }
+	release := func(*Object) {}
+
+	// the content is kept for the other names until the walk ends
+	a := add("a.txt")
+	if err := l.write(a, write, release); err != nil {
+		t.Fatal(err)
+	}
+	if c := a.hardLink.content; c == nil || c.data == nil {
+		t.Fatal("a.txt: content is not kept")
+	}
+	b := add("b.txt")
+	l.walkDone()
+	if err := l.write(b, write, release); err != nil {
+		t.Fatal(err)
+	}
+	if a.hardLink.content.data != nil {
+		t.Error("a.txt: content is kept after the walk")
+	}
+	if b.hardLink.content != nil {
+		t.Error("b.txt: content is kept with no other names walked")
+	}
+	if l.memory != 0 {
+		t.Errorf("got %d bytes kept", l.memory)
+	}
+	l.release(release)
+}
@@ -3,6 +3,7 @@ package pzip
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"hash/crc32"
@@ -13,8 +14,12 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/zdz1715/pzip/flate"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
 )
 
 const (
@@ -31,13 +36,88 @@ type Object struct {
 	Path string
 	Info os.FileInfo
 
-	compressedData  *bytes.Buffer
+	// open returns the content of Path, reopen reports whether it can be
+	// called more than once.
+	open   func() (io.ReadCloser, error)
+	reopen bool
+	// buffered reports whether stored data is kept in compressedData
+	// instead of being read from the source again.
+	buffered bool
+	// stream reports whether the size is unknown until the source is read.
+	stream bool
+	// ahead is the content read by a reader, source returns it once.
+	ahead *readAhead
+	// dst receives the compressed data instead of compressedData if not nil.
+	dst io.Writer
+	// password encrypts the data with WinZip AES-256 if not empty.
+	password  string
+	encrypter *aesEncrypter
+	// nameEncoding writes the name in a legacy encoding if not nil.
+	nameEncoding encoding.Encoding
+	// unicodeExtra writes the name in nameEncoding, or CP437 if nil, followed
+	// by the Info-ZIP Unicode extra fields instead of the UTF-8 flag.
+	unicodeExtra bool
+	// extraTimes writes the access and creation times, and the NTFS times.
+	extraTimes bool
+	// xattrs writes the extended attributes of the file.
+	xattrs bool
+	// ownerNames writes the user and group names of the owner.
+	ownerNames bool
+	// hardLink groups the names of a hard linked file, linked reports whether
+	// this is not the first one, whose content is copied from linkContent.
+	hardLink    *hardLink
+	linked      bool
+	linkContent *linkContent
+	// dedup hashes the content before it is compressed, to find an identical file.
+	dedup *dedup
+	// formats decide whether the content is compressed already, defaultFormatRules if nil.
+	formats *formatRules
+	// rules set the method and level of the file, the first matching one applies.
+	rules []Rule
+
+	// level is the deflate level of files without rules, compressLevel is the
+	// level of this file, of the method in the header.
+	level         int
+	compressLevel int
+	newCompressor flate.NewWriterFunc
+	// compressor is the deflate writer of compressorLevel, zstdEncoder the
+	// zstd encoder of zstdLevel, they are kept for the next files.
 	compressor      flate.Writer
-	header          *FileHeader
-	overflow        *os.File
-	written         uint64
-	compressMinSize uint64
-	link            string
+	compressorLevel int
+	zstdEncoder     *zstd.Encoder
+	zstdLevel       int
+
+	// temps records the overflow file created, if not nil.
+	temps *tempFiles
+	// budget bounds the objects in flight and their overflow files if not nil,
+	// tempDisk is the size of the overflow file reserved from it.
+	budget   *budget
+	tempDisk int64
+	// direct creates regions of the archive if not nil, writeDirect reports
+	// whether the data is written into one, instead of an overflow file.
+	direct       *directWriter
+	writeDirect  bool
+	region       *Region
+	regionSize   int64
+	regionWanted bool
+	granted      chan *Region
+	regionClosed chan error
+
+	// ctx stops copying the content when it is done, if not nil.
+	ctx context.Context
+	// seq is the order the object is submitted in, of ordered archives.
+	seq uint64
+	// batch are the objects compressed and written with this one, including
+	// it, if it is the first of a batch of small files.
+	batch []*Object
+	// pool is the pool the object is taken from, if any.
+	pool *ObjectPool
+
+	compressedData *bytes.Buffer
+	header         *FileHeader
+	overflow       *os.File
+	written        uint64
+	link           string
 }
 
 type ObjectPool struct {
@@ -62,9 +142,16 @@ func NewObjectPool() *ObjectPool {
 
 func (o *ObjectPool) New(path string, info os.FileInfo, level int, fw ...flate.NewWriterFunc) (*Object, error) {
 	obj := o.pool.Get().(*Object)
+	obj.pool = o
 	return obj, obj.Reset(path, info, level, fw...)
 }
 
+func (o *ObjectPool) NewEntry(entry *Entry, level int, fw ...flate.NewWriterFunc) (*Object, error) {
+	obj := o.pool.Get().(*Object)
+	obj.pool = o
+	return obj, obj.ResetEntry(entry, level, fw...)
+}
+
 func (o *ObjectPool) Put(obj *Object) {
 	o.pool.Put(obj)
 }
@@ -74,12 +161,7 @@ func (o *Object) Reset(path string, info os.FileInfo, level int, fw ...flate.New
 		return errors.New("invalid path or info")
 	}
 
-	if err := validLevel(level); err != nil {
-		return err
-	}
-
 	var (
-		hdr  *FileHeader
 		link string
 		err  error
 	)
@@ -90,40 +172,131 @@ func (o *Object) Reset(path string, info os.FileInfo, level int, fw ...flate.New
 		}
 	}
 
-	if hdr, err = zip.FileInfoHeader(info); err != nil {
-		return err
+	open := func() (io.ReadCloser, error) {
+		return os.Open(path)
 	}
-	hdr.Name = HeaderName(path)
 
-	if o.compressor == nil {
-		if len(fw) > 0 && fw[0] != nil {
-			o.compressor, err = fw[0](o, level)
-		} else {
-			o.compressor, err = flate.NewFastWriter(o, level)
+	// pipes and devices can only be read once, archive their content
+	// as a regular file of unknown size
+	if info.Mode()&(os.ModeNamedPipe|os.ModeCharDevice) != 0 {
+		entry := &Entry{
+			Name:     path,
+			Size:     -1,
+			Mode:     info.Mode().Perm(),
+			Modified: info.ModTime(),
 		}
+		return o.reset(path, entry.info(), "", open, false, level, fw...)
+	}
+
+	return o.reset(path, info, link, open, true, level, fw...)
+}
+
+// ResetEntry is like Reset, but takes the name, metadata and content from entry.
+func (o *Object) ResetEntry(entry *Entry, level int, fw ...flate.NewWriterFunc) error {
+	if entry == nil || entry.Name == "" {
+		return errors.New("invalid entry name")
+	}
+
+	open, reopen := entry.opener()
+	if open == nil && !entry.Mode.IsDir() {
+		return fmt.Errorf("entry %q has neither Reader nor Open", entry.Name)
+	}
+
+	info := entry.info()
+	var link string
+	if IsSymlink(info.Mode()) {
+		b, err := readAll(open)
 		if err != nil {
-			return err
+			return fmt.Errorf("read link of %q: %w", entry.Name, err)
 		}
-	} else {
-		o.compressor.Reset(o)
+		link = string(b)
+	}
+
+	return o.reset(entry.Name, info, link, open, reopen, level, fw...)
+}
+
+func (o *Object) reset(path string, info os.FileInfo, link string, open func() (io.ReadCloser, error), reopen bool, level int, fw ...flate.NewWriterFunc) error {
+	if err := validLevel(level); err != nil {
+		return err
+	}
+
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = HeaderName(path)
+
+	stream := info.Size() < 0 && !info.IsDir()
+	if stream {
+		hdr.UncompressedSize = 0
+		hdr.UncompressedSize64 = 0
+	}
+
+	o.newCompressor = nil
+	if len(fw) > 0 {
+		o.newCompressor = fw[0]
 	}
 
 	o.Path = path
 	o.Info = info
+	o.open = open
+	o.reopen = reopen
+	o.buffered = false
+	o.stream = stream
+	o.ahead = nil
+	o.dst = nil
+	o.password = ""
+	o.encrypter = nil
+	o.nameEncoding = nil
+	o.unicodeExtra = false
+	o.extraTimes = false
+	o.xattrs = false
+	o.ownerNames = false
+	o.hardLink = nil
+	o.linked = false
+	o.linkContent = nil
+	o.dedup = nil
+	o.formats = nil
+	o.rules = nil
+	o.temps = nil
+	o.budget = nil
+	o.tempDisk = 0
+	o.direct = nil
+	o.writeDirect = false
+	o.region = nil
+	o.regionWanted = false
+	o.granted = nil
+	o.regionClosed = nil
+	o.ctx = nil
+	o.seq = 0
+	o.batch = nil
+	o.level = level
+	o.compressLevel = level
 	o.header = hdr
 	o.compressedData.Reset()
 	o.overflow = nil
 	o.written = 0
 	o.link = link
-	if level > 6 {
-		o.compressMinSize = 44
-	} else {
-		o.compressMinSize = 128
-	}
 	return nil
 }
 
 func (o *Object) Write(p []byte) (n int, err error) {
+	if o.encrypter != nil {
+		if _, err = o.write(o.encrypter.encrypt(p)); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return o.write(p)
+}
+
+func (o *Object) write(p []byte) (n int, err error) {
+	if o.dst != nil {
+		n, err = o.dst.Write(p)
+		o.written += uint64(n)
+		return n, err
+	}
+
 	totalLen := len(p)
 	if o.compressedData.Available() != 0 {
 		maxWriteable := min(o.compressedData.Available(), totalLen)
@@ -131,9 +304,22 @@ func (o *Object) Write(p []byte) (n int, err error) {
 		o.compressedData.Write(p[:maxWriteable])
 		p = p[maxWriteable:]
 	}
+	if len(p) > 0 && o.writeDirect {
+		if err = o.startRegion(); err != nil {
+			return len(p), fmt.Errorf("write %q into the archive: %w", o.Path, err)
+		}
+		n, err = o.dst.Write(p)
+		o.written += uint64(n)
+		return totalLen, err
+	}
 	if len(p) > 0 {
+		if o.budget != nil {
+			if err = o.budget.reserveDisk(o, int64(len(p))); err != nil {
+				return len(p), err
+			}
+		}
 		if o.overflow == nil {
-			if o.overflow, err = os.CreateTemp(o.Root, overflowPrefix); err != nil {
+			if o.overflow, err = o.temps.create(o.Root); err != nil {
 				return len(p), fmt.Errorf("create temporary file: %w", err)
 			}
 		}
@@ -146,7 +332,55 @@ func (o *Object) Write(p []byte) (n int, err error) {
 	return totalLen, nil
 }
 
+// encodeName writes the name and comment in the legacy encoding, if any.
+func (o *Object) encodeName() {
+	if o.header.NonUTF8 || o.nameEncoding == nil && !o.unicodeExtra {
+		return
+	}
+	name, comment := o.header.Name, o.header.Comment
+	if !o.unicodeExtra {
+		if encName, encComment, ok := encodeName(o.nameEncoding.NewEncoder(), name, comment); ok {
+			o.header.Name, o.header.Comment = encName, encComment
+			o.header.NonUTF8 = true
+		}
+		return
+	}
+
+	enc := o.nameEncoding
+	if enc == nil {
+		enc = charmap.CodePage437
+	}
+	// characters out of the encoding are replaced, the extra fields have them
+	encName, encComment, ok := encodeName(encoding.ReplaceUnsupported(enc.NewEncoder()), name, comment)
+	if !ok {
+		return
+	}
+	if encName != name {
+		o.header.Extra = append(o.header.Extra, unicodeExtra(unicodePathExtraID, encName, name)...)
+	}
+	if encComment != comment {
+		o.header.Extra = append(o.header.Extra, unicodeExtra(unicodeCommentExtraID, encComment, comment)...)
+	}
+	o.header.Name, o.header.Comment = encName, encComment
+	o.header.NonUTF8 = true
+}
+
 func (o *Object) prepareHeader() error {
+	// rules match the name before it is encoded
+	var rule *Rule
+	if !o.Info.IsDir() && o.link == "" {
+		size := int64(o.header.UncompressedSize64)
+		if o.stream {
+			size = -1
+		}
+		rule, _ = matchRule(o.rules, o.header.Name, size)
+	}
+
+	if o.Info.IsDir() && !strings.HasSuffix(o.header.Name, "/") {
+		o.header.Name += "/" // required
+	}
+	o.encodeName()
+
 	utf8ValidName, utf8RequireName := detectUTF8(o.header.Name)
 	utf8ValidComment, utf8RequireComment := detectUTF8(o.header.Comment)
 	switch {
@@ -157,20 +391,37 @@ func (o *Object) prepareHeader() error {
 	}
 
 	if !o.header.Modified.IsZero() {
-		// Use "extended timestamp" format since this is what Info-ZIP uses.
-		// Nearly every major ZIP implementation uses a different format,
-		// but at least most seem to be able to understand the other formats.
-		//
-		// This format happens to be identical for both local and central header
-		// if modification time is the only timestamp being encoded.
-		var mbuf [9]byte // 2*SizeOf(uint16) + SizeOf(uint8) + SizeOf(uint32)
-		eb := writeBuf(mbuf[:])
-		eb.uint16(extTimeExtraID)
-		eb.uint16(5)                                // Size: SizeOf(uint8) + SizeOf(uint32)
-		eb.uint8(1)                                 // Flags: ModTime
-		eb.uint32(uint32(o.header.Modified.Unix())) // ModTime
-
-		o.header.Extra = append(o.header.Extra, mbuf[:]...)
+		var atime, ctime time.Time
+		if o.extraTimes {
+			atime, ctime = statTimes(o.Path, o.Info)
+		}
+		o.header.Extra = append(o.header.Extra, timeExtra(o.header.Modified, atime, ctime, o.extraTimes)...)
+	}
+
+	if uid, gid, ok := fileOwner(o.Info); ok {
+		o.header.Extra = append(o.header.Extra, ownerExtra(uid, gid, o.ownerNames)...)
+	}
+
+	if o.linked && !o.hardLink.dedup {
+		o.header.Extra = append(o.header.Extra, hardLinkExtra(o.hardLink.name)...)
+	}
+
+	if o.xattrs {
+		attrs, err := readXattrs(o.Path, o.Info)
+		if err != nil {
+			return fmt.Errorf("read extended attributes of %q: %w", o.Path, err)
+		}
+		// the attributes take the rest of the extra field, leaving room for
+		// the zip64 and AES fields added later
+		limit := uint16max - len(o.header.Extra) - zip64ExtraLen
+		if o.encrypted() {
+			limit -= aesExtraLen
+		}
+		extra, err := xattrExtra(attrs, limit)
+		if err != nil {
+			return fmt.Errorf("%q: %w", o.Path, err)
+		}
+		o.header.Extra = append(o.header.Extra, extra...)
 	}
 
 	o.header.CreatorVersion = o.header.CreatorVersion&0xff00 | zipVersion20 // preserve compatibility byte
@@ -179,9 +430,6 @@ func (o *Object) prepareHeader() error {
 
 	// Dir
 	if o.Info.IsDir() {
-		if !strings.HasSuffix(o.header.Name, "/") {
-			o.header.Name += "/" // required
-		}
 		o.header.Method = zip.Store
 		// not write
 		o.header.CompressedSize64 = 0
@@ -197,31 +445,124 @@ func (o *Object) prepareHeader() error {
 		o.header.UncompressedSize64 = size
 	}
 
-	// No need to compress files
-	if size <= o.compressMinSize || IsCompressedFile(o.Path) {
+	// No need to compress files, the method of hard links is copied
+	o.header.Method, o.compressLevel = zip.Deflate, o.level
+	compressMinSize := uint64(128)
+	if o.level > 6 {
+		compressMinSize = 44
+	}
+	switch {
+	case rule != nil && rule.Method == StoreMethod:
 		o.header.Method = zip.Store
-	} else {
-		// File
-		o.header.Method = zip.Deflate
+	case rule != nil && rule.Method != AutoMethod:
+		// the method is explicit, compress small and compressed files too
+		if rule.Method == ZstdMethod {
+			o.header.Method, o.compressLevel = Zstd, rule.Level
+			o.header.ReaderVersion = zipVersion63
+		}
+		if level, ok := rule.level(); ok {
+			o.compressLevel = level
+		}
+	case (!o.stream && size <= compressMinSize) || !o.linked && o.formatRules().compressed(o):
+		o.header.Method = zip.Store
+	case rule != nil:
+		if level, ok := rule.level(); ok {
+			o.compressLevel = level
+		}
+	}
+
+	// The size is unknown, compress into the archive and write the crc32 and
+	// sizes in a data descriptor. Stored entries are buffered instead, since
+	// java.util.zip only accepts data descriptors for DEFLATED entries, so are
+	// encrypted entries.
+	if o.stream && o.header.Method == zip.Deflate && !o.encrypted() {
+		o.header.Flags |= 0x8
 	}
 	return nil
 }
 
+func (o *Object) formatRules() *formatRules {
+	if o.formats == nil {
+		return defaultFormatRules
+	}
+	return o.formats
+}
+
+func (o *Object) hasDataDescriptor() bool {
+	return o.header.Flags&0x8 != 0
+}
+
+func (o *Object) encrypted() bool {
+	return o.password != "" && !o.Info.IsDir()
+}
+
+// encrypt starts encrypting the data written to the object.
+func (o *Object) encrypt() error {
+	var err error
+	if o.encrypter, err = newAESEncrypter(o.password); err != nil {
+		return fmt.Errorf("encrypt %q: %w", o.Path, err)
+	}
+	_, err = o.write(o.encrypter.header())
+	return err
+}
+
+// finishEncrypt writes the authentication code, and marks the header as
+// encrypted with the compression method in the AES extra field.
+func (o *Object) finishEncrypt() error {
+	if _, err := o.write(o.encrypter.authCode()); err != nil {
+		return err
+	}
+	o.encrypter = nil
+
+	o.header.Extra = append(o.header.Extra, aesExtra(o.header.Method)...)
+	o.header.Method = aesMethod
+	o.header.Flags |= 0x1
+	o.header.ReaderVersion = max(o.header.ReaderVersion, zipVersion51)
+	o.header.CompressedSize64 = o.written
+	// AE-2, the authentication code replaces the crc32
+	o.header.CRC32 = 0
+	return nil
+}
+
 func (o *Object) Compress() error {
+	// stop the reader if the content is not read
+	if o.ahead != nil {
+		defer o.ahead.Close()
+	}
 	err := o.prepareHeader()
 	if err != nil {
 		return err
 	}
 
-	if o.Info.IsDir() {
+	if o.dedup != nil {
+		if err = o.dedup.match(o); err != nil {
+			return err
+		}
+	}
+
+	// data descriptor entries are compressed when archived, the content of
+	// hard links and identical files is copied from the first one
+	if o.Info.IsDir() || o.hasDataDescriptor() || o.linked {
 		return nil
 	}
 
-	switch o.header.Method {
-	case zip.Store:
+	if o.encrypted() {
+		if err = o.encrypt(); err != nil {
+			return err
+		}
+	}
+
+	o.writeDirect = o.canWriteDirect()
+	switch {
+	case o.directStore():
+		err = o.storeDirect()
+	case o.header.Method == zip.Store:
 		err = o.store()
-	case zip.Deflate:
+	case o.header.Method == zip.Deflate, o.header.Method == Zstd:
 		err = o.deflate()
+		if o.region != nil {
+			err = o.closeRegion(err)
+		}
 	default:
 		return fmt.Errorf("unknown compress method: %d", o.header.Method)
 	}
@@ -230,68 +571,121 @@ func (o *Object) Compress() error {
 		return err
 	}
 
+	if o.encrypted() {
+		return o.finishEncrypt()
+	}
 	return nil
 }
 
-func (o *Object) deflate() error {
-	hash32 := crc32.NewIEEE()
-	w := io.MultiWriter(o.compressor, hash32)
+// source returns the content to archive, the target of symbolic links.
+func (o *Object) source() (io.ReadCloser, error) {
 	if o.link != "" {
-		bs := []byte(o.link)
-		_, err := w.Write(bs)
-		if err != nil {
-			return err
-		}
-		o.header.CompressedSize64 = o.written
-		o.header.CRC32 = hash32.Sum32()
-		return nil
+		return io.NopCloser(strings.NewReader(o.link)), nil
 	}
+	if o.ahead != nil {
+		ahead := o.ahead
+		o.ahead = nil
+		return ahead, nil
+	}
+	return o.open()
+}
 
-	fd, err := os.Open(o.Path)
+func (o *Object) deflate() error {
+	fd, err := o.source()
 	if err != nil {
 		return err
 	}
 	defer fd.Close()
-	_, err = io.Copy(w, fd)
+
+	compressor, err := o.compressWriter()
+	if err != nil {
+		return err
+	}
+	hash32 := crc32.NewIEEE()
+	n, err := copyContext(o.context(), io.MultiWriter(compressor, hash32), fd)
 	if err != nil {
 		return err
 	}
-	if err = o.compressor.Close(); err != nil {
+	if err = compressor.Close(); err != nil {
 		return fmt.Errorf("close compressor for %q: %w", o.Path, err)
 	}
 
+	o.header.UncompressedSize64 = uint64(n)
 	o.header.CompressedSize64 = o.written
 	o.header.CRC32 = hash32.Sum32()
 	return nil
 }
 
-func (o *Object) store() error {
-	hash32 := crc32.NewIEEE()
-	if o.link != "" {
-		_, err := hash32.Write([]byte(o.link))
+// compressWriter returns the compressor of the method and level of the file,
+// writing to o. Compressors of another level are replaced.
+func (o *Object) compressWriter() (flate.Writer, error) {
+	var err error
+	if o.header.Method == Zstd {
+		if o.zstdEncoder == nil || o.zstdLevel != o.compressLevel {
+			level := zstd.SpeedDefault
+			if o.compressLevel != 0 {
+				level = zstd.EncoderLevelFromZstd(o.compressLevel)
+			}
+			if o.zstdEncoder, err = zstd.NewWriter(o, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1)); err != nil {
+				return nil, err
+			}
+			o.zstdLevel = o.compressLevel
+			return o.zstdEncoder, nil
+		}
+		o.zstdEncoder.Reset(o)
+		return o.zstdEncoder, nil
+	}
+
+	if o.compressor == nil || o.compressorLevel != o.compressLevel {
+		if o.newCompressor != nil {
+			o.compressor, err = o.newCompressor(o, o.compressLevel)
+		} else {
+			o.compressor, err = flate.NewFastWriter(o, o.compressLevel)
+		}
 		if err != nil {
-			return err
+			return nil, err
 		}
-		o.header.CompressedSize64 = o.header.UncompressedSize64
-		o.header.CRC32 = hash32.Sum32()
-		return nil
+		o.compressorLevel = o.compressLevel
+		return o.compressor, nil
 	}
+	o.compressor.Reset(o)
+	return o.compressor, nil
+}
 
-	fd, err := os.Open(o.Path)
+func (o *Object) store() error {
+	readAhead := o.ahead != nil
+	fd, err := o.source()
 	if err != nil {
 		return err
 	}
 	defer fd.Close()
-	_, err = io.Copy(hash32, fd)
+
+	// the source can not be read again when writing, or is encrypted, keep the
+	// data, and that of files read ahead fitting in the buffer
+	hash32 := crc32.NewIEEE()
+	var w io.Writer = hash32
+	if (!o.reopen && o.link == "") || o.encrypter != nil ||
+		readAhead && o.header.UncompressedSize64 <= uint64(o.compressedData.Cap()) {
+		w = io.MultiWriter(o, hash32)
+		o.buffered = true
+	}
+
+	n, err := copyContext(o.context(), w, fd)
 	if err != nil {
 		return err
 	}
+	o.header.UncompressedSize64 = uint64(n)
 	o.header.CompressedSize64 = o.header.UncompressedSize64
 	o.header.CRC32 = hash32.Sum32()
 	return nil
 }
 
 func (o *Object) Archive(w *Writer) error {
+	// the data is written into its region already
+	if o.region != nil {
+		return nil
+	}
+
 	cw, err := w.CreateRaw(o.header)
 	if err != nil {
 		return fmt.Errorf("create raw for %q: %w", o.Path, err)
@@ -301,7 +695,26 @@ func (o *Object) Archive(w *Writer) error {
 		return nil
 	}
 
-	if o.header.Method == zip.Store {
+	if o.linkContent != nil {
+		if err = o.linkContent.writeTo(o.context(), cw); err != nil {
+			return fmt.Errorf("copy hard link content for %q: %w", o.Path, err)
+		}
+		return nil
+	}
+
+	// the writer writes the data descriptor with the sizes set by deflate
+	if o.hasDataDescriptor() {
+		o.dst = cw
+		defer func() {
+			o.dst = nil
+		}()
+		if err = o.deflate(); err != nil {
+			return fmt.Errorf("deflate %q: %w", o.Path, err)
+		}
+		return nil
+	}
+
+	if o.header.Method == zip.Store && !o.buffered {
 		if o.link != "" {
 			if _, err = io.Copy(cw, strings.NewReader(o.link)); err != nil {
 				return fmt.Errorf("store %q: %w", o.Path, err)
@@ -309,23 +722,25 @@ func (o *Object) Archive(w *Writer) error {
 			return nil
 		}
 
-		fd, err := os.Open(o.Path)
+		fd, err := o.open()
 		if err != nil {
 			return fmt.Errorf("store %q: %w", o.Path, err)
 		}
 		defer fd.Close()
-		if _, err = io.Copy(cw, fd); err != nil {
+		// write exactly the size in the header even if the source changed
+		if _, err = copyNContext(o.context(), cw, fd, int64(o.header.CompressedSize64)); err != nil {
 			return fmt.Errorf("store %q: %w", o.Path, err)
 		}
 	} else {
-		if _, err = io.Copy(cw, o.compressedData); err != nil {
+		// the data is kept for hard links of the file
+		if _, err = cw.Write(o.compressedData.Bytes()); err != nil {
 			return fmt.Errorf("write compressed data for %q: %w", o.Path, err)
 		}
 		if o.Overflowed() {
 			if _, err = o.overflow.Seek(0, io.SeekStart); err != nil {
 				return fmt.Errorf("seek overflow for %q: %w", o.Path, err)
 			}
-			if _, err = io.Copy(cw, o.overflow); err != nil {
+			if _, err = copyContext(o.context(), cw, o.overflow); err != nil {
 				return fmt.Errorf("copy overflow for %q: %w", o.Path, err)
 			}
 		}
@@ -343,6 +758,9 @@ func (o *Object) Overflowed() bool {
 }
 
 func (o *Object) Close() error {
+	if o.ahead != nil {
+		_ = o.ahead.Close()
+	}
 	if o.Overflowed() {
 		if err := o.overflow.Close(); err != nil {
 			return fmt.Errorf("close overflow file: %w", err)
@@ -352,6 +770,22 @@ func (o *Object) Close() error {
 	return nil
 }
 
+// ActualMethod returns the compression method of a file header with method
+// and extra, which is in the AES extra field if the file is AES encrypted.
+func ActualMethod(method uint16, extra []byte) uint16 {
+	if method != aesMethod {
+		return method
+	}
+	_ = parseExtra(extra, func(id uint16, field readBuf) error {
+		if id == aesExtraID && len(field) >= 7 {
+			field.sub(5)
+			method = field.uint16()
+		}
+		return nil
+	})
+	return method
+}
+
 func IsSymlink(mode fs.FileMode) bool {
 	return mode&os.ModeSymlink != 0
 }
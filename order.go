@@ -0,0 +1,37 @@
+package pzip
+
+// sequencer passes the objects to the writer in the order of their seq, the
+// order they are submitted in, holding those compressed before the previous ones.
+type sequencer struct {
+	next uint64
+	held map[uint64]*Object
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{held: make(map[uint64]*Object)}
+}
+
+// write writes obj and the held objects following it with write, or holds it.
+func (s *sequencer) write(obj *Object, write func(*Object) error) error {
+	if obj.seq != s.next {
+		s.held[obj.seq] = obj
+		return nil
+	}
+	for obj != nil {
+		s.next++
+		if err := write(obj); err != nil {
+			return err
+		}
+		obj = s.held[s.next]
+		delete(s.held, s.next)
+	}
+	return nil
+}
+
+// release releases the objects still held, after the write worker stops.
+func (s *sequencer) release(release func(*Object)) {
+	for _, obj := range s.held {
+		release(obj)
+	}
+	clear(s.held)
+}
@@ -0,0 +1,71 @@
+package pzip
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func archiveNames(t *testing.T, opts *ArchiveOptions) []string {
+	path := filepath.Join(t.TempDir(), "test.zip")
+	if err := Archive(context.Background(), path, opts); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	names := make([]string, 0, len(r.File))
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestArchive_Ordered(t *testing.T) {
+	root := makeTree(t, 40, 5)
+	// larger files take longer to compress than the next ones
+	for i, name := range []string{"d0/big.txt", "d3/e3/big.log", "d5/big.txt"} {
+		data := strings.Repeat("compressible data of a large file\n", (i+1)*100000)
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newOpts := func(concurrency int) *ArchiveOptions {
+		return &ArchiveOptions{
+			Files:       []string{root},
+			Recurse:     true,
+			Concurrency: concurrency,
+			SkipPath:    SkipPath{Excludes: []string{"*.log", "**/e1*/f2.txt"}},
+		}
+	}
+	want := archiveNames(t, newOpts(1))
+
+	opts := newOpts(8)
+	opts.Ordered = true
+	opts.WalkConcurrency = 4
+	if got := archiveNames(t, opts); !slices.Equal(got, want) {
+		t.Errorf("got %d entries not in the order of the walk, want %d", len(got), len(want))
+	}
+
+	opts = newOpts(8)
+	opts.WalkConcurrency = 4
+	got := archiveNames(t, opts)
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("got %d entries of the parallel walk, want %d", len(got), len(want))
+	}
+
+	opts = newOpts(2)
+	opts.Ordered = true
+	opts.DirectWrite = true
+	if err := opts.Validate(); err == nil {
+		t.Error("got no error of ordered direct writes")
+	}
+}
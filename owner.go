@@ -0,0 +1,296 @@
+package pzip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ownerNamesExtraID is the extra field of pzip with the user and group names
+// of the uid and gid of the Info-ZIP new Unix extra field, written with
+// ArchiveOptions.OwnerNames. The field is:
+//
+//	version       uint8, 1
+//	user length   uint8
+//	user          the user name, empty if unknown
+//	group length  uint8
+//	group         the group name, empty if unknown
+const ownerNamesExtraID = 0x6e75
+
+// Owner is the owner of an archived file.
+type Owner struct {
+	Uid, Gid    int
+	User, Group string // empty if unknown
+}
+
+// ownerExtra returns the Info-ZIP new Unix extra field of uid and gid, followed
+// by the names extra field if names is set and they are known.
+func ownerExtra(uid, gid int, names bool) []byte {
+	var buf [15]byte
+	b := writeBuf(buf[:])
+	b.uint16(infoZipNewUnixExtraID)
+	b.uint16(11)
+	b.uint8(1) // version
+	b.uint8(4) // uid size
+	b.uint32(uint32(uid))
+	b.uint8(4) // gid size
+	b.uint32(uint32(gid))
+	extra := buf[:]
+	if !names {
+		return extra
+	}
+
+	userName, groupName := ownerNames(uid, gid)
+	if userName == "" && groupName == "" || len(userName) > 255 || len(groupName) > 255 {
+		return extra
+	}
+	size := 3 + len(userName) + len(groupName)
+	extra = binary.LittleEndian.AppendUint16(extra, ownerNamesExtraID)
+	extra = binary.LittleEndian.AppendUint16(extra, uint16(size))
+	extra = append(extra, 1) // version
+	extra = append(extra, byte(len(userName)))
+	extra = append(extra, userName...)
+	extra = append(extra, byte(len(groupName)))
+	return append(extra, groupName...)
+}
+
+// ownerNameCache caches the user and group names of IDs, looking them up
+// reads the system databases.
+var ownerNameCache = struct {
+	sync.Mutex
+	users, groups map[int]string
+}{users: map[int]string{}, groups: map[int]string{}}
+
+func ownerNames(uid, gid int) (userName, groupName string) {
+	ownerNameCache.Lock()
+	defer ownerNameCache.Unlock()
+	userName, ok := ownerNameCache.users[uid]
+	if !ok {
+		if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+			userName = u.Username
+		}
+		ownerNameCache.users[uid] = userName
+	}
+	groupName, ok = ownerNameCache.groups[gid]
+	if !ok {
+		if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+			groupName = g.Name
+		}
+		ownerNameCache.groups[gid] = groupName
+	}
+	return userName, groupName
+}
+
+// FileOwner returns the owner recorded in the extra fields of f, from the
+// Info-ZIP new Unix field, or the Info-ZIP and PKWARE Unix fields.
+func FileOwner(f *File) (owner Owner, ok bool) {
+	var newUnix bool
+	_ = parseExtra(f.Extra, func(id uint16, field readBuf) error {
+		switch id {
+		case infoZipNewUnixExtraID:
+			// version, uid size, uid, gid size, gid
+			if len(field) < 1 || field.uint8() != 1 {
+				return nil
+			}
+			uid, okUid := readOwnerID(&field)
+			gid, okGid := readOwnerID(&field)
+			if okUid && okGid {
+				owner.Uid, owner.Gid, ok, newUnix = uid, gid, true, true
+			}
+		case infoZipUnixExtraID, unixExtraID:
+			// atime, mtime, uid, gid, the central field has the times only
+			if !newUnix && len(field) >= 12 {
+				field = field[8:]
+				owner.Uid, owner.Gid, ok = int(field.uint16()), int(field.uint16()), true
+			}
+		case ownerNamesExtraID:
+			if len(field) < 2 || field.uint8() != 1 {
+				return nil
+			}
+			userName, okUser := readOwnerName(&field)
+			groupName, okGroup := readOwnerName(&field)
+			if okUser && okGroup {
+				owner.User, owner.Group = userName, groupName
+			}
+		}
+		return nil
+	})
+	if !newUnix {
+		// the names are of the new Unix field only
+		owner.User, owner.Group = "", ""
+	}
+	return owner, ok
+}
+
+func readOwnerID(b *readBuf) (int, bool) {
+	if len(*b) < 1 {
+		return 0, false
+	}
+	size := int(b.uint8())
+	if size < 1 || size > 8 || len(*b) < size {
+		return 0, false
+	}
+	var buf [8]byte
+	copy(buf[:], b.sub(size))
+	id := binary.LittleEndian.Uint64(buf[:])
+	if id > 1<<31-1 {
+		return 0, false
+	}
+	return int(id), true
+}
+
+func readOwnerName(b *readBuf) (string, bool) {
+	if len(*b) < 1 {
+		return "", false
+	}
+	size := int(b.uint8())
+	if len(*b) < size {
+		return "", false
+	}
+	return string(b.sub(size)), true
+}
+
+// OwnerMap maps archived users and groups to those of this system. Keys and
+// values are names, or decimal IDs prefixed with "+".
+type OwnerMap struct {
+	Users  map[string]string
+	Groups map[string]string
+}
+
+// ParseOwnerMap parses lines of "OLD NEW" from r, both are [USER][:GROUP], such
+// as "alice:staff bob:users", "+1000 root" or ":+50 :wheel". Empty lines and
+// lines starting with "#" are ignored.
+func ParseOwnerMap(r io.Reader) (*OwnerMap, error) {
+	m := &OwnerMap{Users: map[string]string{}, Groups: map[string]string{}}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("owner map line %d: want OLD NEW, got %q", line, text)
+		}
+		oldUser, oldGroup, _ := strings.Cut(fields[0], ":")
+		newUser, newGroup, _ := strings.Cut(fields[1], ":")
+		if (oldUser == "") != (newUser == "") || (oldGroup == "") != (newGroup == "") || oldUser == "" && oldGroup == "" {
+			return nil, fmt.Errorf("owner map line %d: OLD and NEW must have the same user and group parts, got %q", line, text)
+		}
+		for _, name := range []string{oldUser, oldGroup, newUser, newGroup} {
+			if _, err := parseOwnerID(name); err != nil {
+				return nil, fmt.Errorf("owner map line %d: %w", line, err)
+			}
+		}
+		if oldUser != "" {
+			m.Users[oldUser] = newUser
+		}
+		if oldGroup != "" {
+			m.Groups[oldGroup] = newGroup
+		}
+	}
+	return m, scanner.Err()
+}
+
+// parseOwnerID returns the ID of "+ID", or -1 if name is not an ID.
+func parseOwnerID(name string) (int, error) {
+	s, ok := strings.CutPrefix(name, "+")
+	if !ok {
+		return -1, nil
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 0 {
+		return -1, fmt.Errorf("invalid ID %q", name)
+	}
+	return id, nil
+}
+
+// ownerResolver resolves archived owners to the IDs of this system.
+type ownerResolver struct {
+	numeric  bool
+	ownerMap *OwnerMap
+
+	mu            sync.Mutex
+	users, groups map[string]int // -1 if unknown
+}
+
+func newOwnerResolver(numeric bool, ownerMap *OwnerMap) *ownerResolver {
+	return &ownerResolver{numeric: numeric, ownerMap: ownerMap, users: map[string]int{}, groups: map[string]int{}}
+}
+
+// resolve returns the uid and gid to extract files of owner with.
+func (r *ownerResolver) resolve(owner Owner) (uid, gid int, err error) {
+	var users, groups map[string]string
+	if r.ownerMap != nil {
+		users, groups = r.ownerMap.Users, r.ownerMap.Groups
+	}
+	if uid, err = r.resolveID(users, r.users, owner.User, owner.Uid, lookupUser); err != nil {
+		return 0, 0, fmt.Errorf("user: %w", err)
+	}
+	if gid, err = r.resolveID(groups, r.groups, owner.Group, owner.Gid, lookupGroup); err != nil {
+		return 0, 0, fmt.Errorf("group: %w", err)
+	}
+	return uid, gid, nil
+}
+
+func (r *ownerResolver) resolveID(m map[string]string, cache map[string]int, name string, id int, lookup func(string) (int, error)) (int, error) {
+	target, mapped := "", false
+	if name != "" {
+		target, mapped = m[name]
+	}
+	if !mapped {
+		target, mapped = m["+"+strconv.Itoa(id)]
+	}
+	if mapped {
+		if mappedID, _ := parseOwnerID(target); mappedID >= 0 {
+			return mappedID, nil
+		}
+		mappedID := r.lookup(cache, target, lookup)
+		if mappedID < 0 {
+			return 0, fmt.Errorf("unknown name %q", target)
+		}
+		return mappedID, nil
+	}
+
+	if !r.numeric && name != "" {
+		if localID := r.lookup(cache, name, lookup); localID >= 0 {
+			return localID, nil
+		}
+	}
+	return id, nil
+}
+
+func (r *ownerResolver) lookup(cache map[string]int, name string, lookup func(string) (int, error)) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id, ok := cache[name]
+	if !ok {
+		var err error
+		if id, err = lookup(name); err != nil {
+			id = -1
+		}
+		cache[name] = id
+	}
+	return id
+}
+
+func lookupUser(name string) (int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+func lookupGroup(name string) (int, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(g.Gid)
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package pzip
+
+import "io/fs"
+
+// fileOwner returns false, files have no Unix owner.
+func fileOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,140 @@
+package pzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestObject_Owner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix owner")
+	}
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := DefaultObjectPool.New(path, info, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DefaultObjectPool.Put(obj)
+
+	// the names are written with ownerNames only
+	for _, names := range []bool{false, true} {
+		obj.header.Extra = nil
+		obj.ownerNames = names
+		if err = obj.prepareHeader(); err != nil {
+			t.Fatal(err)
+		}
+
+		f := new(File)
+		f.Extra = obj.header.Extra
+		owner, ok := FileOwner(f)
+		if !ok || owner.Uid != os.Getuid() || owner.Gid != os.Getgid() {
+			t.Fatalf("got %+v, %v, want uid %d, gid %d", owner, ok, os.Getuid(), os.Getgid())
+		}
+		want := ""
+		if u, err := user.Current(); err == nil && names {
+			want = u.Username
+		}
+		if owner.User != want {
+			t.Errorf("names %v: got user %q, want %q", names, owner.User, want)
+		}
+	}
+}
+
+func TestParseOwnerMap(t *testing.T) {
+	m, err := ParseOwnerMap(strings.NewReader(`
+# archived IDs
++1000:+50 +2000:+60
+alice bob
+:staff :users
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantUsers := map[string]string{"+1000": "+2000", "alice": "bob"}
+	wantGroups := map[string]string{"+50": "+60", "staff": "users"}
+	if !equalMaps(m.Users, wantUsers) || !equalMaps(m.Groups, wantGroups) {
+		t.Errorf("got %v, %v, want %v, %v", m.Users, m.Groups, wantUsers, wantGroups)
+	}
+
+	for _, line := range []string{"alice", "alice :staff", "+x +1", ":staff bob"} {
+		if _, err = ParseOwnerMap(strings.NewReader(line)); err == nil {
+			t.Errorf("%q: got nil error", line)
+		}
+	}
+}
+
+func equalMaps(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestExtractFrom_RestoreOwner(t *testing.T) {
+	root, err := user.Lookup("root")
+	if err != nil || root.Uid != "0" {
+		t.Skip("no root user")
+	}
+
+	// Info-ZIP new Unix field of 1000:50
+	ids := []byte{0x75, 0x78, 11, 0, 1, 4, 0xe8, 0x03, 0, 0, 4, 50, 0, 0, 0}
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, h := range []*zip.FileHeader{
+		{Name: "ids.txt", Extra: ids},
+		// the name is preferred to the ID
+		{Name: "root.txt", Extra: append(ids, 0x75, 0x6e, 7, 0, 1, 4, 'r', 'o', 'o', 't', 0)},
+	} {
+		h.SetMode(0644)
+		if _, err = zw.CreateHeader(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		opts     ExtractOptions
+		ids      [2]int
+		rootUser int
+	}{
+		{ExtractOptions{}, [2]int{1000, 50}, 0},
+		{ExtractOptions{NumericOwner: true}, [2]int{1000, 50}, 1000},
+		{ExtractOptions{OwnerMap: &OwnerMap{Users: map[string]string{"+1000": "+2000"}, Groups: map[string]string{"+50": "root"}}}, [2]int{2000, 0}, 2000},
+	} {
+		mfs := NewMemFS()
+		opts := tt.opts
+		opts.Concurrency = 1
+		opts.FS = mfs
+		opts.RestoreOwner = true
+		if err = ExtractFrom(context.Background(), bytes.NewReader(buf.Bytes()), int64(buf.Len()), &opts); err != nil {
+			t.Fatal(err)
+		}
+		if f := mfs.files["ids.txt"]; f.uid != tt.ids[0] || f.gid != tt.ids[1] {
+			t.Errorf("ids.txt: got %d:%d, want %d:%d", f.uid, f.gid, tt.ids[0], tt.ids[1])
+		}
+		if f := mfs.files["root.txt"]; f.uid != tt.rootUser {
+			t.Errorf("root.txt: got uid %d, want %d", f.uid, tt.rootUser)
+		}
+	}
+}
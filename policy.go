@@ -0,0 +1,244 @@
+package pzip
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Zstd is the compression method of Zstandard, APPNOTE 4.4.5.
+const Zstd = zstd.ZipMethodWinZip
+
+// RuleMethod is the compression method set by a Rule.
+type RuleMethod int
+
+const (
+	// AutoMethod decides the method as for files without rules, by their
+	// size and format.
+	AutoMethod RuleMethod = iota
+	StoreMethod
+	DeflateMethod
+	ZstdMethod
+)
+
+// Rule sets the compression method and level of the regular files it matches.
+type Rule struct {
+	// Pattern matches names like SkipPath, a pattern without "/" matches
+	// the base name, such as "*.log". Empty matches all names.
+	Pattern string
+	// Suffix matches names ending with it, ignoring case, like zip -n.
+	Suffix string
+	// MinSize and MaxSize match files of sizes in the range, MaxSize is
+	// unlimited if 0. Files of unknown size only match rules without them.
+	MinSize int64
+	MaxSize int64
+
+	Method RuleMethod
+	// Level is the deflate level, or the zstd level from 1 to 22. 0 keeps
+	// ArchiveOptions.Level for deflate, and the default level of zstd, unless
+	// LevelSet is true, which sets deflate level 0.
+	Level    int
+	LevelSet bool
+}
+
+// level returns the level set by r, if any.
+func (r *Rule) level() (int, bool) {
+	return r.Level, r.Level != 0 || r.LevelSet
+}
+
+// match reports whether r matches the file name of size, which is -1 if unknown.
+func (r *Rule) match(name string, size int64) bool {
+	if r.Pattern != "" {
+		target := name
+		if !strings.Contains(r.Pattern, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := doublestar.Match(r.Pattern, target); !ok {
+			return false
+		}
+	}
+	if r.Suffix != "" && !strings.HasSuffix(strings.ToLower(name), strings.ToLower(r.Suffix)) {
+		return false
+	}
+	if r.MinSize > 0 || r.MaxSize > 0 {
+		if size < 0 || size < r.MinSize || r.MaxSize > 0 && size > r.MaxSize {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Rule) validate() error {
+	if r.Pattern != "" && !doublestar.ValidatePattern(r.Pattern) {
+		return fmt.Errorf("invalid pattern %q", r.Pattern)
+	}
+	if r.MinSize < 0 || r.MaxSize < 0 || r.MaxSize > 0 && r.MaxSize < r.MinSize {
+		return fmt.Errorf("invalid size range %d-%d", r.MinSize, r.MaxSize)
+	}
+	switch r.Method {
+	case AutoMethod, StoreMethod, DeflateMethod:
+		if level, ok := r.level(); ok {
+			return validLevel(level)
+		}
+	case ZstdMethod:
+		if r.Level < 0 || r.Level > 22 || r.LevelSet && r.Level == 0 {
+			return fmt.Errorf("invalid zstd level %d: want value in range [1, 22]", r.Level)
+		}
+	default:
+		return fmt.Errorf("unknown method %d", r.Method)
+	}
+	return nil
+}
+
+// matchRule returns the first of rules that matches the file name of size.
+func matchRule(rules []Rule, name string, size int64) (*Rule, bool) {
+	for i := range rules {
+		if rules[i].match(name, size) {
+			return &rules[i], true
+		}
+	}
+	return nil, false
+}
+
+// ParseRule parses a rule of conditions and an action separated by "->", such
+// as "*.log -> deflate 6", "size > 1GiB -> level 1" or "assets/** -> zstd 3".
+// Conditions are a pattern and "size OP SIZE" with OP of >, >=, < and <=.
+// Actions are "store", "deflate [LEVEL]", "zstd [LEVEL]" and "level LEVEL".
+// Deflate levels 7 to 9 are rejected, the default compressor writes data
+// that unzip rejects at these levels.
+func ParseRule(s string) (Rule, error) {
+	var r Rule
+	cond, action, ok := strings.Cut(s, "->")
+	if !ok {
+		return r, fmt.Errorf("rule %q: want CONDITIONS -> ACTION", s)
+	}
+
+	fields := strings.Fields(cond)
+	for i := 0; i < len(fields); i++ {
+		if fields[i] != "size" {
+			if r.Pattern != "" {
+				return r, fmt.Errorf("rule %q: more than one pattern", s)
+			}
+			r.Pattern = fields[i]
+			continue
+		}
+		if i+2 >= len(fields) {
+			return r, fmt.Errorf("rule %q: want size OP SIZE", s)
+		}
+		size, err := ParseSize(fields[i+2])
+		if err != nil {
+			return r, fmt.Errorf("rule %q: %w", s, err)
+		}
+		if size == math.MaxInt64 && fields[i+1] == ">" {
+			return r, fmt.Errorf("rule %q: invalid size %q", s, fields[i+2])
+		}
+		switch fields[i+1] {
+		case ">":
+			r.MinSize = size + 1
+		case ">=":
+			r.MinSize = size
+		case "<":
+			r.MaxSize = size - 1
+		case "<=":
+			r.MaxSize = size
+		default:
+			return r, fmt.Errorf("rule %q: unknown operator %q", s, fields[i+1])
+		}
+		if r.MaxSize == 0 && fields[i+1][0] == '<' {
+			// MaxSize 0 is unlimited, match empty files by a pattern instead
+			return r, fmt.Errorf("rule %q: the size limit must be at least 1", s)
+		}
+		i += 2
+	}
+
+	fields = strings.Fields(action)
+	if len(fields) == 0 || len(fields) > 2 {
+		return r, fmt.Errorf("rule %q: want ACTION [LEVEL]", s)
+	}
+	switch fields[0] {
+	case "store":
+		r.Method = StoreMethod
+	case "deflate":
+		r.Method = DeflateMethod
+	case "zstd":
+		r.Method = ZstdMethod
+	case "level":
+		if len(fields) != 2 {
+			return r, fmt.Errorf("rule %q: want level LEVEL", s)
+		}
+	default:
+		return r, fmt.Errorf("rule %q: unknown action %q", s, fields[0])
+	}
+	if len(fields) == 2 {
+		if r.Method == StoreMethod {
+			return r, fmt.Errorf("rule %q: store has no level", s)
+		}
+		level, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return r, fmt.Errorf("rule %q: invalid level %q", s, fields[1])
+		}
+		if r.Method != ZstdMethod && level >= 7 {
+			return r, fmt.Errorf("rule %q: deflate level %d is not supported, the compressor writes invalid data at levels 7 to 9", s, level)
+		}
+		r.Level, r.LevelSet = level, true
+	}
+	if err := r.validate(); err != nil {
+		return r, fmt.Errorf("rule %q: %w", s, err)
+	}
+	return r, nil
+}
+
+// ParseRules parses a rule per line of r, empty lines and lines starting with
+// "#" are ignored.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule, err := ParseRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// StoreSuffixes returns the rules storing files with the suffixes separated by
+// ":" or ";", like zip -n ".mp3:.jpg".
+func StoreSuffixes(s string) []Rule {
+	var rules []Rule
+	for _, suffix := range strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == ';' }) {
+		rules = append(rules, Rule{Suffix: suffix, Method: StoreMethod})
+	}
+	return rules
+}
+
+// ParseSize parses a size such as 100m, 1GiB or 4096, a number without unit
+// is in bytes. Sizes that overflow int64 are invalid.
+func ParseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.ToLower(s), "b")
+	num = strings.TrimSuffix(num, "i")
+	unit := int64(1)
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("kmgt", num[n-1]); i >= 0 {
+			unit = int64(1) << (10 * (i + 1))
+			num = num[:n-1]
+		}
+	}
+	size, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || size < 0 || size > math.MaxInt64/unit {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return size * unit, nil
+}
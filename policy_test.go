@@ -0,0 +1,195 @@
+package pzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    Rule
+		wantErr bool
+	}{
+		{rule: "*.log -> deflate 6", want: Rule{Pattern: "*.log", Method: DeflateMethod, Level: 6, LevelSet: true}},
+		{rule: "*.log -> deflate 0", want: Rule{Pattern: "*.log", Method: DeflateMethod, LevelSet: true}},
+		{rule: "*.bin -> store", want: Rule{Pattern: "*.bin", Method: StoreMethod}},
+		{rule: "size > 1GiB -> level 1", want: Rule{MinSize: 1<<30 + 1, Level: 1, LevelSet: true}},
+		{rule: "assets/** -> zstd 3", want: Rule{Pattern: "assets/**", Method: ZstdMethod, Level: 3, LevelSet: true}},
+		{rule: "assets/** -> zstd 19", want: Rule{Pattern: "assets/**", Method: ZstdMethod, Level: 19, LevelSet: true}},
+		{rule: "*.txt size >= 1m size <= 2m -> zstd", want: Rule{Pattern: "*.txt", MinSize: 1 << 20, MaxSize: 2 << 20, Method: ZstdMethod}},
+		{rule: "size < 4k -> store", want: Rule{MaxSize: 4<<10 - 1, Method: StoreMethod}},
+		{rule: "*.log", wantErr: true},
+		{rule: "*.log -> gzip", wantErr: true},
+		{rule: "*.log -> store 1", wantErr: true},
+		{rule: "*.log -> deflate 10", wantErr: true},
+		{rule: "*.log -> deflate 9", wantErr: true},
+		{rule: "*.log -> level 7", wantErr: true},
+		{rule: "*.log -> zstd 0", wantErr: true},
+		{rule: "*.log -> zstd 23", wantErr: true},
+		{rule: "*.log -> level", wantErr: true},
+		{rule: "size ~ 1 -> store", wantErr: true},
+		{rule: "size < 1 -> store", wantErr: true},
+		{rule: "size > 99999999999t -> store", wantErr: true},
+		{rule: "size > 9223372036854775807 -> store", wantErr: true},
+		{rule: "size > 2 size < 2 -> store", wantErr: true},
+		{rule: "*.a *.b -> store", wantErr: true},
+		{rule: "[ -> store", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRule(tt.rule)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: got error %v, want error %v", tt.rule, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{
+		{s: "4096", want: 4096},
+		{s: "100m", want: 100 << 20},
+		{s: "1GiB", want: 1 << 30},
+		{s: "8388607t", want: 8388607 << 40},
+		{s: "8388608t", wantErr: true},
+		{s: "99999999999t", wantErr: true},
+		{s: "-1k", wantErr: true},
+		{s: "1x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%q: got %d, %v, want %d", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader("# media\n\n*.mp4 -> store\n  *.log -> deflate 6\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].Pattern != "*.mp4" || rules[1].Level != 6 {
+		t.Errorf("got %+v", rules)
+	}
+	if _, err = ParseRules(strings.NewReader("*.mp4 -> store\nbad\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("got error %v, want error of line 2", err)
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	rules := append(StoreSuffixes(".mp3:.JPG"),
+		Rule{Pattern: "assets/**", Method: ZstdMethod},
+		Rule{Pattern: "*.log", Method: DeflateMethod, Level: 9},
+		Rule{MinSize: 100, MaxSize: 200, Level: 1},
+	)
+	tests := []struct {
+		name string
+		size int64
+		want int // index of rules, -1 if none
+	}{
+		{"music/a.MP3", 10, 0},
+		{"photo.jpg", 10, 1},
+		{"assets/css/a.log", 10, 2},
+		{"dir/a.log", 10, 3},
+		{"a.txt", 150, 4},
+		{"a.txt", 201, -1},
+		{"a.txt", -1, -1},
+		{"b/assets/a", 10, -1},
+	}
+	for _, tt := range tests {
+		rule, ok := matchRule(rules, tt.name, tt.size)
+		switch {
+		case tt.want < 0 && ok:
+			t.Errorf("%s %d: got %+v, want no rule", tt.name, tt.size, *rule)
+		case tt.want >= 0 && rule != &rules[tt.want]:
+			t.Errorf("%s %d: got %v, want rule %d", tt.name, tt.size, rule, tt.want)
+		}
+	}
+}
+
+func TestArchiveTo_Rules(t *testing.T) {
+	text := strings.Repeat("hello world\n", 1000)
+	opts := &ArchiveOptions{
+		Concurrency: 2,
+		Level:       6,
+		Rules: []Rule{
+			{Suffix: ".bin", Method: StoreMethod},
+			{Pattern: "assets/**", Method: ZstdMethod, Level: 3},
+			{Pattern: "*.gz", Method: DeflateMethod},
+			{Pattern: "*.log", Level: 9},
+			{Pattern: "*.raw", Method: DeflateMethod, LevelSet: true},
+		},
+		Entries: []Entry{
+			{Name: "a.bin", Size: int64(len(text)), Mode: 0644, Open: openString(text)},
+			{Name: "assets/a.css", Size: int64(len(text)), Mode: 0644, Open: openString(text)},
+			{Name: "assets/stdin", Size: -1, Mode: 0644, Reader: strings.NewReader(text)},
+			{Name: "small.gz", Size: 2, Mode: 0644, Open: openString("hi")},
+			{Name: "a.log", Size: int64(len(text)), Mode: 0644, Open: openString(text)},
+			{Name: "a.txt", Size: int64(len(text)), Mode: 0644, Open: openString(text)},
+			{Name: "a.raw", Size: int64(len(text)), Mode: 0644, Open: openString(text)},
+		},
+	}
+	buf := new(bytes.Buffer)
+	if err := ArchiveTo(context.Background(), buf, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]uint16{
+		"a.bin":        zip.Store,
+		"assets/a.css": Zstd,
+		"assets/stdin": Zstd,
+		"small.gz":     zip.Deflate,
+		"a.log":        zip.Deflate,
+		"a.txt":        zip.Deflate,
+		"a.raw":        zip.Deflate,
+	}
+	for _, f := range r.File {
+		if f.Method != want[f.Name] {
+			t.Errorf("%s: got method %d, want %d", f.Name, f.Method, want[f.Name])
+		}
+		if f.Method == Zstd && f.ReaderVersion != zipVersion63 {
+			t.Errorf("%s: got reader version %d, want %d", f.Name, f.ReaderVersion, zipVersion63)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		wantContent := text
+		if f.Name == "small.gz" {
+			wantContent = "hi"
+		}
+		if string(b) != wantContent {
+			t.Errorf("%s: content mismatch", f.Name)
+		}
+		// deflate level 0 stores the data in deflate blocks
+		if f.Name == "a.raw" && f.CompressedSize64 < f.UncompressedSize64 {
+			t.Errorf("%s: got %d bytes compressed at level 0", f.Name, f.CompressedSize64)
+		}
+	}
+
+	opts.Rules = []Rule{{Pattern: "*", Method: ZstdMethod, Level: 30}}
+	if err = ArchiveTo(context.Background(), io.Discard, opts); err == nil {
+		t.Error("got no error of an invalid zstd level")
+	}
+}
@@ -0,0 +1,207 @@
+package pzip
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/zip"
+)
+
+const (
+	// readChunkSize is the size of the chunks read ahead, readAheadChunks is
+	// the number of chunks read ahead of each file before its reader waits.
+	readChunkSize   = 256 << 10
+	readAheadChunks = 4
+)
+
+// errReadStopped is returned by the content of files whose reader is stopped
+// by a failed worker.
+var errReadStopped = errors.New("read ahead stopped")
+
+var readChunks = sync.Pool{
+	New: func() any {
+		b := make([]byte, readChunkSize)
+		return &b
+	},
+}
+
+type readChunk struct {
+	buf  *[]byte
+	data []byte
+}
+
+// readAhead is the content of a file read sequentially by a reader for the
+// worker consuming it, which is read once.
+type readAhead struct {
+	chunks chan readChunk
+	// err is set by the reader before chunks is closed.
+	err error
+	// done is closed by Close to stop the reader.
+	done      chan struct{}
+	closeOnce sync.Once
+	// pending are the chunks received and not read yet.
+	pending []readChunk
+}
+
+func newReadAhead() *readAhead {
+	return &readAhead{chunks: make(chan readChunk, readAheadChunks), done: make(chan struct{})}
+}
+
+// fill reads the content opened by open until it is read, the consumer
+// closes a or stop is closed.
+func (a *readAhead) fill(open func() (io.ReadCloser, error), stop <-chan struct{}) {
+	defer close(a.chunks)
+	rc, err := open()
+	if err != nil {
+		a.err = err
+		return
+	}
+	defer rc.Close()
+	if f, ok := rc.(*os.File); ok {
+		adviseSequential(f, readChunkSize*readAheadChunks)
+	}
+	for {
+		buf := readChunks.Get().(*[]byte)
+		n, err := io.ReadFull(rc, *buf)
+		if n > 0 {
+			select {
+			case a.chunks <- readChunk{buf: buf, data: (*buf)[:n]}:
+			case <-a.done:
+				return
+			case <-stop:
+				a.err = errReadStopped
+				return
+			}
+		} else {
+			readChunks.Put(buf)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return
+		}
+		if err != nil {
+			a.err = err
+			return
+		}
+	}
+}
+
+// receive receives the next chunk into pending, it returns false at the end.
+func (a *readAhead) receive() bool {
+	chunk, ok := <-a.chunks
+	if ok {
+		a.pending = append(a.pending, chunk)
+	}
+	return ok
+}
+
+// peek returns up to n bytes of the head of the content, which is still read.
+func (a *readAhead) peek(n int) ([]byte, error) {
+	var size int
+	for _, chunk := range a.pending {
+		size += len(chunk.data)
+	}
+	for size < n && a.receive() {
+		size += len(a.pending[len(a.pending)-1].data)
+	}
+	if size < n && a.err != nil {
+		return nil, a.err
+	}
+	head := make([]byte, 0, min(size, n))
+	for _, chunk := range a.pending {
+		head = append(head, chunk.data[:min(len(chunk.data), n-len(head))]...)
+	}
+	return head, nil
+}
+
+func (a *readAhead) Read(p []byte) (int, error) {
+	if len(a.pending) == 0 && !a.receive() {
+		if a.err != nil {
+			return 0, a.err
+		}
+		return 0, io.EOF
+	}
+	chunk := &a.pending[0]
+	n := copy(p, chunk.data)
+	if chunk.data = chunk.data[n:]; len(chunk.data) == 0 {
+		readChunks.Put(chunk.buf)
+		a.pending = a.pending[1:]
+	}
+	return n, nil
+}
+
+// Close stops the reader, the rest of the content is not read.
+func (a *readAhead) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.done)
+	})
+	return nil
+}
+
+// readsAhead reports whether the content of o can be read by a reader. The
+// content of hard links and identical files is copied from the others.
+func (o *Object) readsAhead() bool {
+	return o.reopen && !o.stream && o.link == "" && o.Info.Mode().IsRegular() && !o.linked && o.dedup == nil
+}
+
+// openRaw returns the uncompressed content of file, whose data is read from raw.
+func openRaw(file *File, raw io.ReadCloser) (io.ReadCloser, error) {
+	if file.Method == zip.Store {
+		return raw, nil
+	}
+	dcomp := decompressor(file.Method)
+	if dcomp == nil {
+		_ = raw.Close()
+		return nil, ErrAlgorithm
+	}
+	rc := dcomp(raw)
+	return &rawReader{
+		Reader: &checksumReader{rc: rc, hash: crc32.NewIEEE(), file: file},
+		rc:     rc,
+		raw:    raw,
+	}, nil
+}
+
+// rawReader closes the decompressor and the data it reads.
+type rawReader struct {
+	io.Reader
+	rc, raw io.Closer
+}
+
+func (r *rawReader) Close() error {
+	return errors.Join(r.rc.Close(), r.raw.Close())
+}
+
+// extractJob is a file to extract, whose raw data is read ahead if ahead is not nil.
+type extractJob struct {
+	file  *File
+	ahead *readAhead
+}
+
+func (j *extractJob) openRaw() (io.ReadCloser, error) {
+	raw, err := j.file.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(raw), nil
+}
+
+// sortByOffset sorts jobs by the offsets of the data of their files, those of
+// unreadable headers are last.
+func sortByOffset(jobs []*extractJob) {
+	offsets := make(map[*extractJob]int64, len(jobs))
+	for _, job := range jobs {
+		offset, err := job.file.DataOffset()
+		if err != nil {
+			offset = math.MaxInt64
+		}
+		offsets[job] = offset
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return offsets[jobs[i]] < offsets[jobs[j]]
+	})
+}
@@ -0,0 +1,130 @@
+package pzip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadAhead(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", readChunkSize/4))
+	a := newReadAhead()
+	go a.fill(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}, nil)
+
+	head, err := a.peek(readChunkSize + 10)
+	if err != nil || !bytes.Equal(head, data[:readChunkSize+10]) {
+		t.Fatalf("got head of %d bytes, %v", len(head), err)
+	}
+	got, err := io.ReadAll(a)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes, %v", len(got), err)
+	}
+	_ = a.Close()
+
+	// open errors are returned by reads
+	errOpen := errors.New("open")
+	a = newReadAhead()
+	go a.fill(func() (io.ReadCloser, error) { return nil, errOpen }, nil)
+	if _, err = io.ReadAll(a); !errors.Is(err, errOpen) {
+		t.Errorf("got %v, want %v", err, errOpen)
+	}
+
+	// the reader stops when the rest is not read
+	a = newReadAhead()
+	filled := make(chan struct{})
+	go func() {
+		a.fill(func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(bytes.Repeat(data, 8))), nil
+		}, nil)
+		close(filled)
+	}()
+	_ = a.Close()
+	<-filled
+}
+
+func TestArchive_ReadConcurrency(t *testing.T) {
+	random := make([]byte, 3<<20)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	files := map[string]string{
+		"random.bin":   string(random),
+		"photo.jpg":    string(random[:100<<10]),
+		"text.txt":     strings.Repeat("hello world\n", 300000),
+		"small.txt":    strings.Repeat("small\n", 100),
+		"empty.txt":    "",
+		"sub/a.txt":    strings.Repeat("a", 1000),
+		"sub/b/c.data": string(random[:1<<20]),
+	}
+	dir := writeTestTree(t, files)
+
+	for _, password := range []string{"", "secret"} {
+		path := filepath.Join(t.TempDir(), "test.zip")
+		opts := &ArchiveOptions{
+			Files:           []string{dir},
+			Recurse:         true,
+			Concurrency:     4,
+			ReadConcurrency: 1,
+			InodeOrder:      true,
+			Password:        password,
+			TrialSize:       4 << 10,
+		}
+		if err := Archive(context.Background(), path, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		mfs := NewMemFS()
+		extractOpts := &ExtractOptions{Concurrency: 4, ReadConcurrency: 2, FS: mfs, Password: password}
+		if err := Extract(context.Background(), path, extractOpts); err != nil {
+			t.Fatal(err)
+		}
+		checkMemFS(t, mfs, dir, files)
+	}
+
+	opts := &ArchiveOptions{Files: []string{dir}, Concurrency: 1, ReadConcurrency: -1}
+	if err := opts.Validate(); err == nil {
+		t.Error("got no error of a negative read concurrency")
+	}
+}
+
+func TestExtract_ReadConcurrencyCanceled(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("%02d.txt", i)] = strings.Repeat("x", 1000)
+	}
+	path := filepath.Join(t.TempDir(), "test.zip")
+	if err := Archive(context.Background(), path, &ArchiveOptions{Files: []string{writeTestTree(t, files)}, Recurse: true, Concurrency: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the read worker stops at once, the extraction is still in After
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var after atomic.Int32
+	opts := &ExtractOptions{
+		Concurrency:     1,
+		ReadConcurrency: 1,
+		FS:              NewMemFS(),
+		After: func(*File, *ExtractTarget) {
+			cancel()
+			time.Sleep(50 * time.Millisecond)
+			after.Add(1)
+		},
+	}
+	if err := Extract(ctx, path, opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	n := after.Load()
+	time.Sleep(100 * time.Millisecond)
+	if got := after.Load(); got == 0 || got != n {
+		t.Errorf("After was called %d times, %d before Extract returned", got, n)
+	}
+}
@@ -1,8 +1,85 @@
 package pzip
 
-import "github.com/klauspost/compress/zip"
+import (
+	"errors"
+	"io"
+	"os"
 
-type ReadCloser = zip.ReadCloser
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdDecompressor decompresses Zstd entries, File.Open supports them as well.
+var zstdDecompressor = zstd.ZipDecompressor()
+
+func init() {
+	zip.RegisterDecompressor(Zstd, zstdDecompressor)
+}
+
+type Reader = zip.Reader
 type File = zip.File
 
-var OpenReader = zip.OpenReader
+var NewReader = zip.NewReader
+
+// ReadCloser is an opened archive, which may be split into segments.
+type ReadCloser struct {
+	*Reader
+	files []*os.File
+}
+
+// OpenReader opens the archive at path. If it is the last segment of a split
+// archive, the other segments .z01, .z02, ... beside it are opened as well.
+func OpenReader(path string) (*ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	rc := &ReadCloser{files: []*os.File{f}}
+
+	var (
+		r    io.ReaderAt = f
+		size int64
+	)
+	if r, size, err = rc.openSegments(path); err != nil {
+		_ = rc.Close()
+		return nil, err
+	}
+
+	// like zip.OpenReader, the reader is returned with ErrInsecurePath
+	if rc.Reader, err = NewReader(r, size); err != nil && !errors.Is(err, ErrInsecurePath) {
+		_ = rc.Close()
+		return nil, err
+	}
+	return rc, err
+}
+
+// Close closes the archive and its segments.
+func (r *ReadCloser) Close() error {
+	var err error
+	for _, f := range r.files {
+		err = errors.Join(err, f.Close())
+	}
+	return err
+}
+
+var (
+	ErrFormat    = zip.ErrFormat
+	ErrAlgorithm = zip.ErrAlgorithm
+	ErrChecksum  = zip.ErrChecksum
+
+	ErrInsecurePath = zip.ErrInsecurePath
+)
+
+// decompressor returns the decompressor of method, or nil if it is not supported.
+func decompressor(method uint16) zip.Decompressor {
+	switch method {
+	case zip.Store:
+		return io.NopCloser
+	case zip.Deflate:
+		return flate.NewReader
+	case Zstd:
+		return zstdDecompressor
+	}
+	return nil
+}
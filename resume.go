@@ -0,0 +1,381 @@
+package pzip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// partialName and journalName are the partial archive of Resume and its
+	// journal, in the directory .pzip-<name>.resume beside the archive.
+	partialName = "partial.zip"
+	journalName = "journal"
+)
+
+// journalEntry is an entry written to the partial archive, which is a line of
+// JSON of the journal. Path, Size and ModTime are of the file archived, the
+// entry is kept if they are unchanged.
+type journalEntry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+
+	Header *FileHeader
+	// Offset is of the local header, End is where the data and data descriptor end.
+	Offset             uint64
+	End                uint64
+	Offset32           uint32
+	CompressedSize32   uint32
+	UncompressedSize32 uint32
+}
+
+// resume is the partial archive of an archive written with Resume, and the
+// journal of its entries.
+type resume struct {
+	dir     string
+	archive *os.File
+	journal *os.File
+	// end is where the last entry of the journal ends.
+	end uint64
+
+	// entries are the entries of the journal, the last of each path, and
+	// kept marks the ones kept by the walk.
+	entries []*journalEntry
+	byPath  map[string]int
+	kept    []bool
+	// buf is the line of the entry written.
+	buf bytes.Buffer
+}
+
+// resumeDir returns the directory of the partial archive of absZipPath.
+func resumeDir(absZipPath string) string {
+	return filepath.Join(filepath.Dir(absZipPath), ".pzip-"+filepath.Base(absZipPath)+".resume")
+}
+
+// openResume opens the partial archive in dir and its journal, which are
+// created if dir does not exist. The journal starts with the line returned by
+// options for the salt of the line, the entries are discarded if it does not.
+// The entries after the last one of the journal whose end is verified are
+// truncated.
+func openResume(dir string, options func(salt []byte) ([]byte, error)) (r *resume, err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	r = &resume{dir: dir, byPath: make(map[string]int)}
+	defer func() {
+		if err != nil {
+			_ = r.close()
+		}
+	}()
+	if r.archive, err = os.OpenFile(filepath.Join(dir, partialName), os.O_RDWR|os.O_CREATE, 0600); err != nil {
+		return nil, err
+	}
+	if r.journal, err = os.OpenFile(filepath.Join(dir, journalName), os.O_RDWR|os.O_CREATE, 0600); err != nil {
+		return nil, err
+	}
+
+	line, _ := bufio.NewReader(r.journal).ReadBytes('\n')
+	var head journalHead
+	_ = json.Unmarshal(line, &head)
+	want, err := options(head.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(line, want) {
+		salt := make([]byte, aesSaltLen)
+		if _, err = rand.Read(salt); err != nil {
+			return nil, err
+		}
+		if line, err = options(salt); err != nil {
+			return nil, err
+		}
+		if err = r.journal.Truncate(0); err != nil {
+			return nil, err
+		}
+		if _, err = r.journal.WriteAt(line, 0); err != nil {
+			return nil, err
+		}
+		if err = r.archive.Truncate(0); err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := r.archive.Stat()
+	if err != nil {
+		return nil, err
+	}
+	entries, lines, err := readJournal(r.journal, int64(len(line)), uint64(info.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+	// verify the tail, the last entries may not be written completely
+	for len(entries) > 0 && !verifyEntry(r.archive, entries[len(entries)-1]) {
+		entries, lines = entries[:len(entries)-1], lines[:len(lines)-1]
+	}
+
+	journalSize := int64(len(line))
+	if len(entries) > 0 {
+		r.end = entries[len(entries)-1].End
+		journalSize = lines[len(lines)-1]
+	}
+	if err = r.archive.Truncate(int64(r.end)); err != nil {
+		return nil, err
+	}
+	if _, err = r.archive.Seek(int64(r.end), io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err = r.journal.Truncate(journalSize); err != nil {
+		return nil, err
+	}
+	if _, err = r.journal.Seek(journalSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		// a path written again replaces the entry
+		if i, ok := r.byPath[e.Path]; ok {
+			r.entries[i] = nil
+		}
+		r.byPath[e.Path] = len(r.entries)
+		r.entries = append(r.entries, e)
+	}
+	r.kept = make([]bool, len(r.entries))
+	return r, nil
+}
+
+// readJournal reads the entries of the journal from offset that end within
+// size bytes of the archive, and the end of the line of each. Reading stops
+// at the first line that is not complete.
+func readJournal(f *os.File, offset int64, size uint64) ([]*journalEntry, []int64, error) {
+	var (
+		entries []*journalEntry
+		lines   []int64
+		end     uint64
+	)
+	br := bufio.NewReader(io.NewSectionReader(f, offset, math.MaxInt64-offset))
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			// a line without newline is not written completely
+			return entries, lines, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		offset += int64(len(line))
+		e := new(journalEntry)
+		if json.Unmarshal(line, e) != nil || e.Header == nil || e.Offset < end || e.End < e.Offset || e.End > size {
+			return entries, lines, nil
+		}
+		end = e.End
+		entries = append(entries, e)
+		lines = append(lines, offset)
+	}
+}
+
+// verifyEntry reports whether the local header of e, and its data descriptor
+// if it has one, are written in f.
+func verifyEntry(f *os.File, e *journalEntry) bool {
+	h := e.Header
+	buf := make([]byte, fileHeaderLen+len(h.Name))
+	if _, err := f.ReadAt(buf, int64(e.Offset)); err != nil {
+		return false
+	}
+	crc32 := binary.LittleEndian.Uint32(buf[14:18])
+	if binary.LittleEndian.Uint32(buf) != fileHeaderSignature || string(buf[fileHeaderLen:]) != h.Name {
+		return false
+	}
+	if h.Flags&0x8 == 0 {
+		return crc32 == h.CRC32
+	}
+	if e.End-e.Offset < dataDescriptor64Len {
+		return false
+	}
+	var desc [dataDescriptor64Len]byte
+	if _, err := f.ReadAt(desc[:], int64(e.End-dataDescriptor64Len)); err != nil {
+		return false
+	}
+	return binary.LittleEndian.Uint32(desc[:4]) == dataDescriptorSignature &&
+		binary.LittleEndian.Uint32(desc[4:8]) == h.CRC32 &&
+		binary.LittleEndian.Uint64(desc[8:16]) == h.CompressedSize64 &&
+		binary.LittleEndian.Uint64(desc[16:24]) == h.UncompressedSize64
+}
+
+// writer returns the writer appending to the partial archive.
+func (r *resume) writer() *Writer {
+	w := NewWriter(r.archive)
+	w.cw.count = r.end
+	w.cw.total = r.end
+	return w
+}
+
+// keep reports whether the entry of obj in the partial archive is kept, its
+// file is unchanged. Streams are archived again.
+func (r *resume) keep(obj *Object) bool {
+	i, ok := r.byPath[obj.Path]
+	if !ok || r.kept[i] || obj.stream {
+		return false
+	}
+	e := r.entries[i]
+	if e.Size != obj.Info.Size() || !e.ModTime.Equal(obj.Info.ModTime()) {
+		return false
+	}
+	r.kept[i] = true
+	return true
+}
+
+// record writes the entry of obj, the last one written to w, to the journal
+// once it is written to the partial archive.
+func (r *resume) record(w *Writer, obj *Object) error {
+	if err := w.closeLast(); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	h := w.dir[len(w.dir)-1]
+	if h.FileHeader != obj.header {
+		return fmt.Errorf("journal %q: entry is not the last one written", obj.Path)
+	}
+	e := journalEntry{
+		Path:               obj.Path,
+		Size:               obj.Info.Size(),
+		ModTime:            obj.Info.ModTime(),
+		Header:             h.FileHeader,
+		Offset:             h.offset,
+		End:                w.cw.count,
+		Offset32:           h.offset32,
+		CompressedSize32:   h.compressedSize32,
+		UncompressedSize32: h.uncompressedSize32,
+	}
+	r.buf.Reset()
+	if err := json.NewEncoder(&r.buf).Encode(&e); err != nil {
+		return err
+	}
+	_, err := r.journal.Write(r.buf.Bytes())
+	return err
+}
+
+// addKept adds the entries kept to the central directory of w before the
+// others. The entries of the journal that are not kept are left in the partial
+// archive, no entry is moved and the journal stays valid until the archive is
+// complete.
+func (r *resume) addKept(w *Writer) error {
+	if err := w.closeLast(); err != nil {
+		return err
+	}
+	var headers []*header
+	for i, e := range r.entries {
+		if e == nil || !r.kept[i] {
+			continue
+		}
+		headers = append(headers, &header{
+			FileHeader:         e.Header,
+			offset:             e.Offset,
+			offset32:           e.Offset32,
+			compressedSize32:   e.CompressedSize32,
+			uncompressedSize32: e.UncompressedSize32,
+		})
+	}
+	w.dir = append(headers, w.dir...)
+	return nil
+}
+
+// close closes the partial archive and the journal, which are kept.
+func (r *resume) close() error {
+	var err error
+	if r.archive != nil {
+		err = r.archive.Close()
+	}
+	if r.journal != nil {
+		err = errors.Join(err, r.journal.Close())
+	}
+	return err
+}
+
+// journalHead is the first line of the journal. Options is the hash of the
+// options that change the entries written, Password is a key derived from the
+// password and Salt, like those of encrypted entries.
+type journalHead struct {
+	Options  string
+	Salt     []byte `json:",omitempty"`
+	Password []byte `json:",omitempty"`
+}
+
+// resumeOptions returns the first line of the journal of o with salt.
+func (o *ArchiveOptions) resumeOptions(salt []byte) ([]byte, error) {
+	data, err := json.Marshal(struct {
+		Level            int
+		NameEncoding     string
+		UnicodeExtra     bool
+		ExtraTimes       bool
+		Xattrs           bool
+		OwnerNames       bool
+		Dereference      bool
+		CompressedExts   []string
+		CompressedMagics []Magic
+		TrialSize        int
+		Rules            []Rule
+	}{
+		Level:            o.Level,
+		NameEncoding:     fmt.Sprint(o.NameEncoding),
+		UnicodeExtra:     o.UnicodeExtra,
+		ExtraTimes:       o.ExtraTimes,
+		Xattrs:           o.Xattrs,
+		OwnerNames:       o.OwnerNames,
+		Dereference:      o.Dereference,
+		CompressedExts:   o.CompressedExts,
+		CompressedMagics: o.CompressedMagics,
+		TrialSize:        o.TrialSize,
+		Rules:            o.Rules,
+	})
+	if err != nil {
+		return nil, err
+	}
+	head := journalHead{Options: fmt.Sprintf("%x", sha256.Sum256(data))}
+	if o.Password != "" {
+		head.Salt = salt
+		head.Password = pbkdf2.Key([]byte(o.Password), salt, aesIterations, sha256.Size, sha256.New)
+	}
+	line, err := json.Marshal(&head)
+	return append(line, '\n'), err
+}
+
+// archiveResume writes the archive to the partial archive beside absZipPath,
+// which is renamed to absZipPath once it is complete. The partial archive is
+// kept if the archive fails, and the entries of unchanged files are kept by
+// the next archive.
+func (o *ArchiveOptions) archiveResume(ctx context.Context, absZipPath string) (err error) {
+	dir := resumeDir(absZipPath)
+	r, err := openResume(dir, o.resumeOptions)
+	if err != nil {
+		return fmt.Errorf("resume: %w", err)
+	}
+	o.resume = r
+	defer func() {
+		o.resume = nil
+		if closeErr := r.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			if err = os.Rename(filepath.Join(dir, partialName), absZipPath); err == nil {
+				err = os.RemoveAll(dir)
+			}
+		}
+	}()
+	return o.archive(ctx, r.writer(), absZipPath)
+}
@@ -0,0 +1,178 @@
+package pzip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchive_Resume(t *testing.T) {
+	random := make([]byte, 1<<20)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	files := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		name := fmt.Sprintf("d%d/f%02d.txt", i%3, i)
+		files[name] = strings.Repeat(name, i*100)
+		if i%10 == 5 {
+			files[name] = string(random[:i<<15])
+		}
+	}
+	root := writeTestTree(t, files)
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files[name] = content
+	}
+
+	path := filepath.Join(t.TempDir(), "test.zip")
+	newOpts := func() *ArchiveOptions {
+		return &ArchiveOptions{
+			Files:       []string{root},
+			Recurse:     true,
+			Concurrency: 1,
+			Ordered:     true,
+			BufferSize:  64 << 10,
+			Resume:      true,
+			Entries: []Entry{{
+				Name:     "stdin",
+				Size:     -1,
+				Mode:     0644,
+				Modified: time.Now(),
+				Reader:   strings.NewReader("streamed"),
+			}},
+		}
+	}
+
+	// interrupt kills the archive after some entries
+	interrupt := func(opts *ArchiveOptions) {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var written int
+		opts.After = func(hdr *FileHeader) {
+			if written++; written == 15 {
+				cancel()
+			}
+		}
+		if err := Archive(ctx, path, opts); !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want %v", err, context.Canceled)
+		}
+	}
+	interrupt(newOpts())
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("got the archive of a failed archive, %v", err)
+	}
+	resumeDir := resumeDir(path)
+	// a partial entry and journal line
+	for _, name := range []string{partialName, journalName} {
+		f, err := os.OpenFile(filepath.Join(resumeDir, name), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.WriteString(`{"Path":"partial`)
+		_ = f.Close()
+	}
+
+	// files are changed, removed and added before the archive is resumed
+	write("d0/f00.txt", "changed")
+	if err := os.Chtimes(filepath.Join(root, "d0/f00.txt"), time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "d1/f01.txt")); err != nil {
+		t.Fatal(err)
+	}
+	delete(files, "d1/f01.txt")
+	write("d1/new.txt", "new")
+
+	opts := newOpts()
+	if err := Archive(context.Background(), path, opts); err != nil {
+		t.Fatal(err)
+	}
+	if resumed := opts.Stats().Resumed; resumed == 0 || resumed >= 15 {
+		t.Errorf("got %d entries resumed", resumed)
+	}
+	if _, err := os.Stat(resumeDir); !os.IsNotExist(err) {
+		t.Errorf("got the resume directory left, %v", err)
+	}
+
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the directories and stdin
+	if want := len(files) + 4 + 1; len(r.File) != want {
+		t.Errorf("got %d entries, want %d", len(r.File), want)
+	}
+	_ = r.Close()
+	// the entries of the changed and removed files are not in the central directory
+	mfs := NewMemFS()
+	if err = Extract(context.Background(), path, &ExtractOptions{Concurrency: 1, FS: mfs}); err != nil {
+		t.Fatal(err)
+	}
+	checkMemFS(t, mfs, root, files)
+	checkMemFS(t, mfs, "", map[string]string{"stdin": "streamed"})
+	if _, ok := mfs.files[memName(filepath.Join(root, "d1/f01.txt"))]; ok {
+		t.Error("got the removed file extracted")
+	}
+
+	// the partial archive of other options is discarded
+	interrupt(newOpts())
+	opts = newOpts()
+	opts.Level = 1
+	if err = Archive(context.Background(), path, opts); err != nil {
+		t.Fatal(err)
+	}
+	if resumed := opts.Stats().Resumed; resumed != 0 {
+		t.Errorf("got %d entries resumed of other options", resumed)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs = NewMemFS()
+	if err = ExtractStream(context.Background(), bytes.NewReader(data), &ExtractOptions{Concurrency: 1, FS: mfs}); err != nil {
+		t.Fatal(err)
+	}
+	checkMemFS(t, mfs, root, files)
+
+	// the journal has a key derived from the password, and is not readable by others
+	journal := filepath.Join(resumeDir, journalName)
+	for _, password := range []string{"secret", "other"} {
+		opts = newOpts()
+		opts.Password = "secret"
+		interrupt(opts)
+		if data, err = os.ReadFile(journal); err != nil || bytes.Contains(data, []byte("secret")) {
+			t.Errorf("got the password in the journal, %v", err)
+		}
+		info, err := os.Stat(journal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+			t.Errorf("got journal mode %v", info.Mode())
+		}
+
+		opts = newOpts()
+		opts.Password = password
+		if err = Archive(context.Background(), path, opts); err != nil {
+			t.Fatal(err)
+		}
+		if resumed := opts.Stats().Resumed; (resumed != 0) != (password == "secret") {
+			t.Errorf("%s: got %d entries resumed", password, resumed)
+		}
+	}
+
+	if err := ArchiveTo(context.Background(), io.Discard, &ArchiveOptions{Files: []string{root}, Resume: true}); err == nil {
+		t.Error("got no error of a resumable archive written to a writer")
+	}
+}
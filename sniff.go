@@ -0,0 +1,198 @@
+package pzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/flate"
+)
+
+const (
+	// sniffSize is the head of files read to detect their format.
+	sniffSize = 4 << 10
+	// trialLevel is the level of trial compressions, which estimate the ratio only.
+	trialLevel = 1
+)
+
+// Magic is the magic number of a file format, the content has Bytes at Offset.
+type Magic struct {
+	Offset int
+	Bytes  []byte
+}
+
+// compressedMagics are the magic numbers of common compressed formats. Zip
+// archives are detected by the method of their first file instead.
+var compressedMagics = []Magic{
+	{0, []byte{0x1f, 0x8b}},                       // gzip
+	{0, []byte{0x28, 0xb5, 0x2f, 0xfd}},           // zstd
+	{0, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},   // xz
+	{0, []byte("BZh")},                            // bzip2
+	{0, []byte{0x04, 0x22, 0x4d, 0x18}},           // lz4
+	{0, []byte("LZIP")},                           // lzip
+	{0, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}}, // 7z
+	{0, []byte{'R', 'a', 'r', '!', 0x1a, 0x07}},   // rar
+	{0, []byte("MSCF")},                           // cab
+	{0, []byte{0x89, 'P', 'N', 'G', '\r', '\n'}},  // png
+	{0, []byte{0xff, 0xd8, 0xff}},                 // jpeg
+	{0, []byte("GIF8")},                           // gif
+	{8, []byte("WEBP")},                           // webp
+	{4, []byte("ftyp")},                           // mp4, mov, m4a, heic, avif
+	{0, []byte{0x1a, 0x45, 0xdf, 0xa3}},           // matroska, webm
+	{0, []byte("OggS")},                           // ogg
+	{0, []byte("fLaC")},                           // flac
+	{0, []byte("ID3")},                            // mp3
+	{0, []byte("wOFF")},                           // woff
+	{0, []byte("wOF2")},                           // woff2
+}
+
+// formatRules decide whether files are of compressed formats, which are stored.
+type formatRules struct {
+	exts      map[string]struct{}
+	magics    []Magic
+	trialSize int
+}
+
+// defaultFormatRules are the rules of objects not archived with ArchiveOptions.
+var defaultFormatRules = &formatRules{}
+
+// newFormatRules returns the built-in rules extended with exts and magics.
+func newFormatRules(exts []string, magics []Magic, trialSize int) *formatRules {
+	r := &formatRules{magics: magics, trialSize: trialSize}
+	if len(exts) > 0 {
+		r.exts = make(map[string]struct{}, len(exts))
+		for _, ext := range exts {
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			r.exts[strings.ToLower(ext)] = struct{}{}
+		}
+	}
+	return r
+}
+
+// compressed reports whether the content of o is of a compressed format, from
+// its head if it can be read again, or its extension. A content of no known
+// format is compressed on trial, if trialSize is set.
+func (r *formatRules) compressed(o *Object) bool {
+	if o.link != "" || o.stream || !o.reopen || !o.Info.Mode().IsRegular() {
+		return r.compressedExt(o.Path)
+	}
+	var (
+		head []byte
+		err  error
+	)
+	if o.ahead != nil {
+		head, err = o.ahead.peek(max(sniffSize, r.trialSize))
+	} else {
+		head, err = readHead(o.open, max(sniffSize, r.trialSize))
+	}
+	if err != nil {
+		// the error is reported when the content is read
+		return r.compressedExt(o.Path)
+	}
+	if compressed, known := r.sniff(head); known {
+		return compressed
+	}
+	if r.compressedExt(o.Path) {
+		return true
+	}
+	return r.trialSize > 0 && poorRatio(head[:min(len(head), r.trialSize)])
+}
+
+func (r *formatRules) compressedExt(path string) bool {
+	if IsCompressedFile(path) {
+		return true
+	}
+	_, ok := r.exts[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// sniff reports whether head is of a compressed format, known is false if the
+// format is unknown.
+func (r *formatRules) sniff(head []byte) (compressed, known bool) {
+	if len(head) >= 4 && binary.LittleEndian.Uint32(head) == fileHeaderSignature {
+		return sniffZip(head)
+	}
+	for _, magics := range [][]Magic{compressedMagics, r.magics} {
+		for _, m := range magics {
+			if len(head) >= m.Offset+len(m.Bytes) && bytes.Equal(head[m.Offset:m.Offset+len(m.Bytes)], m.Bytes) {
+				return true, true
+			}
+		}
+	}
+	return false, false
+}
+
+// sniffZip reports whether the first file with content of the zip archive
+// head is compressed, such as a .docx or .jar saved without compression.
+func sniffZip(head []byte) (compressed, known bool) {
+	for len(head) >= fileHeaderLen && binary.LittleEndian.Uint32(head) == fileHeaderSignature {
+		b := readBuf(head[6:])
+		flags := b.uint16()
+		method := b.uint16()
+		b = b[8:] // skip time, date and crc32
+		size := b.uint32()
+		b = b[4:] // skip uncompressed size
+		if flags&0x1 != 0 {
+			return true, true
+		}
+		if method != zip.Store || flags&0x8 != 0 || size > 0 {
+			return method != zip.Store, true
+		}
+		// skip empty files and directories, such as META-INF/ of jars
+		next := fileHeaderLen + int(b.uint16()) + int(b.uint16())
+		if next > len(head) {
+			break
+		}
+		head = head[next:]
+	}
+	return false, false
+}
+
+// readHead returns up to n bytes of the content opened by open.
+func readHead(open func() (io.ReadCloser, error), n int) ([]byte, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	head := make([]byte, n)
+	n, err = io.ReadFull(rc, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return head[:n], err
+}
+
+var trialWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(io.Discard, trialLevel)
+		return w
+	},
+}
+
+// poorRatio reports whether deflating data saves less than a tenth of it.
+func poorRatio(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	w := trialWriters.Get().(*flate.Writer)
+	defer trialWriters.Put(w)
+	var n byteCounter
+	w.Reset(&n)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return int(n) > len(data)*9/10
+}
+
+type byteCounter int
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
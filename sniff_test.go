@@ -0,0 +1,107 @@
+package pzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// testZip returns an archive of files stored or deflated.
+func testZip(t *testing.T, method uint16, names ...string) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, name := range names {
+		h := &zip.FileHeader{Name: name, Method: method}
+		if strings.HasSuffix(name, "/") {
+			h.Method = zip.Store
+		}
+		fw, err := w.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(name, "/") {
+			_, _ = fw.Write([]byte(strings.Repeat("<xml/>", 100)))
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFormatRules_Sniff(t *testing.T) {
+	rules := newFormatRules(nil, []Magic{{Offset: 2, Bytes: []byte("CUSTOM")}}, 0)
+	tests := []struct {
+		name       string
+		head       []byte
+		compressed bool
+		known      bool
+	}{
+		{"gzip", []byte{0x1f, 0x8b, 0x08, 0x00}, true, true},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, true, true},
+		{"mp4", []byte("\x00\x00\x00\x18ftypmp42"), true, true},
+		{"custom", []byte("..CUSTOM.."), true, true},
+		{"text", []byte("hello world"), false, false},
+		{"short", []byte{0x1f}, false, false},
+		{"zip deflated", testZip(t, zip.Deflate, "[Content_Types].xml"), true, true},
+		{"zip stored", testZip(t, zip.Store, "[Content_Types].xml"), false, true},
+		{"jar", testZip(t, zip.Deflate, "META-INF/", "META-INF/MANIFEST.MF"), true, true},
+		{"jar stored", testZip(t, zip.Store, "META-INF/", "META-INF/MANIFEST.MF"), false, true},
+	}
+	for _, tt := range tests {
+		compressed, known := rules.sniff(tt.head)
+		if compressed != tt.compressed || known != tt.known {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, compressed, known, tt.compressed, tt.known)
+		}
+	}
+}
+
+func TestArchiveTo_Sniff(t *testing.T) {
+	random := make([]byte, 1<<16)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	zstd := append([]byte{0x28, 0xb5, 0x2f, 0xfd}, bytes.Repeat([]byte("a"), 1000)...)
+	text := strings.Repeat("hello world\n", 100)
+	docx := testZip(t, zip.Store, "word/document.xml")
+
+	for _, trial := range []int{0, 4096} {
+		opts := &ArchiveOptions{
+			Concurrency:    2,
+			TrialSize:      trial,
+			CompressedExts: []string{"dat"},
+			Entries: []Entry{
+				{Name: "blob", Size: int64(len(zstd)), Mode: 0644, Open: openString(string(zstd))},
+				{Name: "doc.docx", Size: int64(len(docx)), Mode: 0644, Open: openString(string(docx))},
+				{Name: "random", Size: int64(len(random)), Mode: 0644, Open: openString(string(random))},
+				{Name: "text.dat", Size: int64(len(text)), Mode: 0644, Open: openString(text)},
+				{Name: "text.txt", Size: int64(len(text)), Mode: 0644, Open: openString(text)},
+			},
+		}
+		buf := new(bytes.Buffer)
+		if err := ArchiveTo(context.Background(), buf, opts); err != nil {
+			t.Fatal(err)
+		}
+		r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]uint16{
+			"blob":     zip.Store,
+			"doc.docx": zip.Deflate,
+			"random":   zip.Deflate,
+			"text.dat": zip.Store,
+			"text.txt": zip.Deflate,
+		}
+		if trial > 0 {
+			want["random"] = zip.Store
+		}
+		for _, f := range r.File {
+			if f.Method != want[f.Name] {
+				t.Errorf("trial %d: %s: got method %d, want %d", trial, f.Name, f.Method, want[f.Name])
+			}
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package pzip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// directoryEnd is the end of central directory record, with the zip64 values if present.
+type directoryEnd struct {
+	disk      uint32 // number of the last disk
+	dirDisk   uint32
+	records   uint64
+	dirSize   uint64
+	dirOffset uint64
+	comment   string
+
+	// zip64 end of central directory record location
+	zip64     bool
+	loc64Disk uint32
+	loc64     uint64
+}
+
+// readDirectoryEnd reads the end of central directory record of the last segment r.
+func readDirectoryEnd(r io.ReaderAt, size int64) (*directoryEnd, error) {
+	n := min(size, directoryEndLen+uint16max)
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, size-n); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	// 4.3.16 the record is followed by the comment only
+	p := -1
+	for i := len(buf) - directoryEndLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(buf[i:]) == directoryEndSignature &&
+			i+directoryEndLen+int(binary.LittleEndian.Uint16(buf[i+directoryEndLen-2:])) <= len(buf) {
+			p = i
+			break
+		}
+	}
+	if p < 0 {
+		return nil, ErrFormat
+	}
+
+	b := readBuf(buf[p+4:])
+	end := &directoryEnd{}
+	end.disk = uint32(b.uint16())
+	end.dirDisk = uint32(b.uint16())
+	b = b[2:] // skip records on this disk
+	end.records = uint64(b.uint16())
+	end.dirSize = uint64(b.uint32())
+	end.dirOffset = uint64(b.uint32())
+	end.comment = string(b.sub(int(b.uint16())))
+
+	// 4.3.15 the zip64 locator precedes the record
+	if p >= directory64LocLen {
+		b = readBuf(buf[p-directory64LocLen:])
+		if b.uint32() == directory64LocSignature {
+			end.zip64 = true
+			end.loc64Disk = b.uint32()
+			end.loc64 = b.uint64()
+			if disks := b.uint32(); disks > 0 {
+				end.disk = disks - 1
+			}
+		}
+	}
+	if end.disk == uint16max && !end.zip64 {
+		return nil, ErrFormat
+	}
+	return end, nil
+}
+
+// readDirectory64End reads the zip64 values of end from the segments r.
+func (end *directoryEnd) readDirectory64End(r *multiReaderAt) error {
+	if int(end.loc64Disk) >= len(r.parts) {
+		return ErrFormat
+	}
+	var buf [directory64EndLen]byte
+	if _, err := r.ReadAt(buf[:], r.starts[end.loc64Disk]+int64(end.loc64)); err != nil {
+		return fmt.Errorf("read zip64 end of central directory: %w", err)
+	}
+
+	// 4.3.14 Zip64 end of central directory record
+	b := readBuf(buf[:])
+	if b.uint32() != directory64EndSignature {
+		return ErrFormat
+	}
+	b = b[12:] // skip size, version made by and version needed to extract
+	b = b[4:]  // skip number of this disk
+	end.dirDisk = b.uint32()
+	b = b[8:] // skip records on this disk
+	end.records = b.uint64()
+	end.dirSize = b.uint64()
+	end.dirOffset = b.uint64()
+	return nil
+}
+
+// openSegments returns the archive of path as a single ReaderAt. If it is the
+// last segment of a split archive, the segments .z01, .z02, ... are opened and
+// followed by a central directory with offsets in the joined segments.
+func (rc *ReadCloser) openSegments(path string) (io.ReaderAt, int64, error) {
+	last := rc.files[0]
+	info, err := last.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	end, err := readDirectoryEnd(last, info.Size())
+	if err != nil || end.disk == 0 {
+		// let NewReader report errors of a single archive
+		return last, info.Size(), nil
+	}
+
+	r := new(multiReaderAt)
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for disk := uint32(1); disk <= end.disk; disk++ {
+		f, err := os.Open(fmt.Sprintf("%s.z%02d", base, disk))
+		if err != nil {
+			return nil, 0, fmt.Errorf("open segment %d of %s: %w", disk, path, err)
+		}
+		rc.files = append(rc.files, f)
+		if info, err = f.Stat(); err != nil {
+			return nil, 0, err
+		}
+		r.add(f, info.Size())
+	}
+	info, _ = last.Stat()
+	r.add(last, info.Size())
+
+	if end.zip64 {
+		if err = end.readDirectory64End(r); err != nil {
+			return nil, 0, err
+		}
+	}
+	dir, err := rebuildDirectory(r, end)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read central directory of %s: %w", path, err)
+	}
+	r.add(bytes.NewReader(dir), int64(len(dir)))
+	return r, r.size(), nil
+}
+
+// rebuildDirectory reads the central directory of the segments r and returns
+// it with the local header offsets in the joined segments, to be appended to them.
+func rebuildDirectory(r *multiReaderAt, end *directoryEnd) ([]byte, error) {
+	if int(end.dirDisk) >= len(r.parts) {
+		return nil, ErrFormat
+	}
+	br := bufio.NewReader(io.NewSectionReader(r, r.starts[end.dirDisk]+int64(end.dirOffset), int64(end.dirSize)))
+
+	dir := make([]*header, 0, min(end.records, 1<<16))
+	for i := uint64(0); i < end.records; i++ {
+		var sig [4]byte
+		if _, err := io.ReadFull(br, sig[:]); err != nil {
+			return nil, err
+		}
+		if binary.LittleEndian.Uint32(sig[:]) != directoryHeaderSignature {
+			return nil, ErrFormat
+		}
+		record, err := readDirectoryHeader(br)
+		if err != nil {
+			return nil, err
+		}
+		if int(record.disk) >= len(r.parts) {
+			return nil, ErrFormat
+		}
+
+		f := record.file
+		h := &header{
+			FileHeader: &FileHeader{
+				Name:               f.Name,
+				Comment:            f.Comment,
+				CreatorVersion:     f.CreatorVersion,
+				ReaderVersion:      f.ReaderVersion,
+				Flags:              f.Flags,
+				Method:             f.Method,
+				ModifiedTime:       f.ModifiedTime,
+				ModifiedDate:       f.ModifiedDate,
+				CRC32:              f.CRC32,
+				CompressedSize:     uint32(min(f.CompressedSize64, uint32max)),
+				UncompressedSize:   uint32(min(f.UncompressedSize64, uint32max)),
+				CompressedSize64:   f.CompressedSize64,
+				UncompressedSize64: f.UncompressedSize64,
+				Extra:              removeExtra(f.Extra, zip64ExtraID),
+				ExternalAttrs:      f.ExternalAttrs,
+			},
+			offset: uint64(r.starts[record.disk]) + record.offset,
+		}
+		h.prepare()
+		dir = append(dir, h)
+	}
+
+	buf := new(bytes.Buffer)
+	total := uint64(r.size())
+	w := &Writer{cw: &countWriter{w: bufio.NewWriter(buf), count: total, total: total}, dir: dir}
+	if err := w.SetComment(end.comment); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// removeExtra returns extra without the fields of id.
+func removeExtra(extra []byte, id uint16) []byte {
+	out := make([]byte, 0, len(extra))
+	for b := extra; len(b) >= 4; {
+		size := 4 + int(binary.LittleEndian.Uint16(b[2:]))
+		if size > len(b) {
+			// keep a malformed field as it is
+			return append(out, b...)
+		}
+		if binary.LittleEndian.Uint16(b) != id {
+			out = append(out, b[:size]...)
+		}
+		b = b[size:]
+	}
+	return out
+}
+
+// multiReaderAt is the concatenation of parts.
+type multiReaderAt struct {
+	parts []io.ReaderAt
+	// starts[i] is the offset of parts[i], the last one is the total size.
+	starts []int64
+}
+
+func (r *multiReaderAt) add(part io.ReaderAt, size int64) {
+	if len(r.starts) == 0 {
+		r.starts = append(r.starts, 0)
+	}
+	r.parts = append(r.parts, part)
+	r.starts = append(r.starts, r.starts[len(r.starts)-1]+size)
+}
+
+func (r *multiReaderAt) size() int64 {
+	if len(r.starts) == 0 {
+		return 0
+	}
+	return r.starts[len(r.starts)-1]
+}
+
+func (r *multiReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("zip: negative offset")
+	}
+	i := sort.Search(len(r.parts), func(i int) bool { return r.starts[i+1] > off })
+	n := 0
+	for ; n < len(p) && i < len(r.parts); i++ {
+		want := min(int64(len(p)-n), r.starts[i+1]-off)
+		m, err := r.parts[i].ReadAt(p[n:n+int(want)], off-r.starts[i])
+		n += m
+		off += int64(m)
+		if int64(m) < want {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,72 @@
+package pzip
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestOpenReader_Split(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string][]byte)
+	var entries []Entry
+	for i := 0; i < 8; i++ {
+		// random data is stored, so the archive spans several segments
+		name := "file" + strconv.Itoa(i) + ".png"
+		data := make([]byte, rand.Intn(3*MinSplitSize/2)+1)
+		rand.Read(data)
+		files[name] = data
+		entries = append(entries, Entry{Name: name, Size: int64(len(data)), Mode: 0644, Open: openString(string(data))})
+	}
+
+	path := filepath.Join(dir, "split.zip")
+	err := Archive(context.Background(), path, &ArchiveOptions{
+		Concurrency: 2,
+		SplitSize:   MinSplitSize,
+		Entries:     entries,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "split.z02")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if len(r.File) != len(files) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(files))
+	}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		if !bytes.Equal(data, files[f.Name]) {
+			t.Errorf("%s: content mismatch", f.Name)
+		}
+	}
+
+	out := filepath.Join(dir, "out")
+	if err = Extract(context.Background(), path, &ExtractOptions{OutDir: out, Concurrency: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range files {
+		if got, err := os.ReadFile(filepath.Join(out, name)); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s: got %d bytes, %v, want %d bytes", name, len(got), err, len(want))
+		}
+	}
+}
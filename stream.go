@@ -0,0 +1,818 @@
+package pzip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+)
+
+const (
+	// streamBufferSize is the largest compressed entry buffered in memory and
+	// extracted in parallel, larger entries are extracted while reading.
+	streamBufferSize = defaultBufSize
+
+	streamReadSize = 1 << 16
+)
+
+// ErrStreamMismatch is returned by ExtractStream if the local file headers
+// do not match the central directory.
+var ErrStreamMismatch = errors.New("zip: local file headers do not match central directory")
+
+// streamEntry is a file read from a local file header.
+type streamEntry struct {
+	file   *File
+	offset uint64
+	// zip64 reports whether the local header has the zip64 extra field.
+	zip64 bool
+	// data is the compressed data of entries extracted in parallel.
+	data []byte
+	// head is the start of the content, the target if the entry is a symbolic link.
+	head []byte
+	// target is nil if the entry is skipped.
+	target *ExtractTarget
+}
+
+// directoryRecord is a central directory header.
+type directoryRecord struct {
+	file   *File
+	disk   uint32
+	offset uint64
+}
+
+// ExtractStream extracts the archive read from r, which does not have to be seekable,
+// such as stdin or a pipe. Files are extracted in the order of their local headers,
+// those small enough to be buffered are written in parallel. The central directory
+// at the end of the stream is verified against the local headers, and the file
+// modes and symbolic links it records are applied once all files are written.
+//
+// opts.Before is not called, since the archive comment is at the end of the stream.
+func ExtractStream(ctx context.Context, r io.Reader, opts *ExtractOptions) error {
+	if opts == nil {
+		return errors.New("extract options must not be nil")
+	}
+	if err := opts.prepare(); err != nil {
+		return err
+	}
+	opts.ctx = ctx
+
+	worker := NewFailFastWorker[streamEntry](func(params *streamEntry) error {
+		return opts.extractStreamEntry(params, bytes.NewReader(params.data))
+	}, opts.Concurrency, opts.Concurrency)
+
+	worker.Start(ctx)
+
+	s := &streamReader{br: bufio.NewReaderSize(r, streamReadSize)}
+	entries, records, err := opts.readStream(s, worker)
+
+	if execErr := worker.Wait(); execErr != nil {
+		err = errors.Join(err, execErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = verifyStream(entries, records); err != nil {
+		return err
+	}
+
+	return opts.applyDirectory(entries, records)
+}
+
+// readStream reads the local file headers and the central directory of s.
+func (o *ExtractOptions) readStream(s *streamReader, worker *FailFastWorker[streamEntry]) ([]*streamEntry, []*directoryRecord, error) {
+	var entries []*streamEntry
+	for {
+		offset := s.offset
+		sig, err := s.uint32()
+		if err != nil {
+			return entries, nil, fmt.Errorf("read signature at offset %d: %w", offset, err)
+		}
+
+		switch {
+		case offset == 0 && (sig == spanningSignature || sig == spanningMarker):
+			continue
+		case sig == fileHeaderSignature:
+			e, err := s.readLocalHeader(offset)
+			if err != nil {
+				return entries, nil, err
+			}
+			decodeName(e.file, o.NameEncoding)
+			entries = append(entries, e)
+
+			if err = o.readStreamEntry(s, e, worker); err != nil {
+				return entries, nil, err
+			}
+		case sig == directoryHeaderSignature:
+			records, err := s.readDirectory()
+			for _, r := range records {
+				decodeName(r.file, o.NameEncoding)
+			}
+			return entries, records, err
+		case sig == directory64EndSignature || sig == directoryEndSignature:
+			// the central directory is empty
+			_, err = io.Copy(io.Discard, s)
+			return entries, nil, err
+		default:
+			return entries, nil, fmt.Errorf("%w: unexpected signature %#08x at offset %d", ErrFormat, sig, offset)
+		}
+	}
+}
+
+// readStreamEntry reads the data of e, small entries are submitted to worker.
+func (o *ExtractOptions) readStreamEntry(s *streamReader, e *streamEntry, worker *FailFastWorker[streamEntry]) error {
+	file := e.file
+	skip := o.Skip(file.Name)
+	if file.Flags&0x8 == 0 {
+		return o.readSizedEntry(s, e, skip, worker)
+	}
+	if file.CompressedSize64 == 0 {
+		return o.readDescriptorEntry(s, e, skip)
+	}
+
+	// Info-ZIP writes the sizes of encrypted files in the local header
+	// as well as in the data descriptor
+	if err := o.readSizedEntry(s, e, skip, worker); err != nil {
+		return err
+	}
+	crc, compressedSize, size, err := s.readDataDescriptor(e.zip64)
+	if err != nil {
+		return fmt.Errorf("read data descriptor of %q: %w", file.Name, err)
+	}
+	if crc != file.CRC32 || compressedSize != file.CompressedSize64 || size != file.UncompressedSize64 {
+		return fmt.Errorf("%q: %w", file.Name, ErrChecksum)
+	}
+	return nil
+}
+
+// readSizedEntry reads the data of e whose sizes are in the local header.
+func (o *ExtractOptions) readSizedEntry(s *streamReader, e *streamEntry, skip bool, worker *FailFastWorker[streamEntry]) error {
+	file := e.file
+	switch {
+	case skip:
+		_, err := copyNContext(o.context(), io.Discard, s, int64(file.CompressedSize64))
+		return err
+	case file.CompressedSize64 <= streamBufferSize:
+		e.data = make([]byte, file.CompressedSize64)
+		if _, err := io.ReadFull(s, e.data); err != nil {
+			return fmt.Errorf("read %q: %w", file.Name, err)
+		}
+		// stop reading, wait error
+		return worker.Submit(e)
+	default:
+		raw := io.LimitReader(s, int64(file.CompressedSize64))
+		if err := o.extractStreamEntry(e, raw); err != nil {
+			return err
+		}
+		// the entry may not read all of its data, e.g. directories
+		_, err := copyContext(o.context(), io.Discard, raw)
+		return err
+	}
+}
+
+// readDescriptorEntry reads an entry whose crc32 and sizes are in a data descriptor,
+// it is extracted while reading since the end of the data is unknown.
+func (o *ExtractOptions) readDescriptorEntry(s *streamReader, e *streamEntry, skip bool) (err error) {
+	file := e.file
+	raw := &countReader{r: s}
+
+	var (
+		rc     io.ReadCloser
+		d      decrypter
+		method = file.Method
+		hasCRC = true
+	)
+	if file.Flags&0x1 != 0 {
+		password, err := o.password(file)
+		if err != nil {
+			return err
+		}
+		if d, method, hasCRC, err = newDecrypter(file, raw, password, -1); err != nil {
+			return fmt.Errorf("%q: %w", file.Name, err)
+		}
+	}
+
+	switch {
+	case method == zip.Store && d != nil:
+		return fmt.Errorf("%q: encrypted stored files with data descriptors are not supported", file.Name)
+	case method == zip.Store:
+		rc = io.NopCloser(&storedDescriptorReader{s: s, hash: crc32.NewIEEE(), zip64: e.zip64})
+	case method == zip.Deflate && d != nil:
+		// the decrypter reads raw byte by byte
+		rc = decompressor(method)(d)
+	case method == zip.Deflate:
+		// deflate ends itself, s is an io.ByteReader so no more is read.
+		rc = decompressor(method)(raw)
+	default:
+		return fmt.Errorf("%q: %w", file.Name, ErrAlgorithm)
+	}
+	defer rc.Close()
+
+	cr := &checksumReader{rc: rc, hash: crc32.NewIEEE()}
+	if skip {
+		_, err = copyContext(o.context(), io.Discard, cr)
+	} else {
+		err = o.extractStreamReader(e, cr)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = copyContext(o.context(), io.Discard, cr); err != nil {
+		return fmt.Errorf("read %q: %w", file.Name, err)
+	}
+	if d != nil {
+		if err = d.finish(); err != nil {
+			return fmt.Errorf("%q: %w", file.Name, err)
+		}
+	}
+
+	compressed := raw.n
+	if file.Method == zip.Store {
+		compressed = cr.n
+	}
+
+	crc, compressedSize, size, err := s.readDataDescriptor(e.zip64 || compressed >= uint32max)
+	if err != nil {
+		return fmt.Errorf("read data descriptor of %q: %w", file.Name, err)
+	}
+	if (hasCRC && crc != cr.hash.Sum32()) || compressedSize != compressed || size != cr.n {
+		return fmt.Errorf("%q: %w", file.Name, ErrChecksum)
+	}
+
+	file.CRC32 = crc
+	file.CompressedSize64 = compressedSize
+	file.UncompressedSize64 = size
+	return nil
+}
+
+// extractStreamEntry extracts e whose compressed data is read from raw.
+func (o *ExtractOptions) extractStreamEntry(e *streamEntry, raw io.Reader) error {
+	if e.file.Flags&0x1 != 0 {
+		rc, err := o.openEncrypted(e.file, raw)
+		if err != nil {
+			return fmt.Errorf("%q: %w", e.file.Name, err)
+		}
+		defer rc.Close()
+		return o.extractStreamReader(e, rc)
+	}
+
+	dcomp := decompressor(e.file.Method)
+	if dcomp == nil {
+		return fmt.Errorf("%q: %w", e.file.Name, ErrAlgorithm)
+	}
+	rc := dcomp(raw)
+	defer rc.Close()
+
+	cr := &checksumReader{rc: rc, hash: crc32.NewIEEE(), file: e.file}
+	return o.extractStreamReader(e, cr)
+}
+
+func (o *ExtractOptions) extractStreamReader(e *streamEntry, r io.Reader) error {
+	// sizes of data descriptor entries are unknown yet
+	if e.file.UncompressedSize64 <= maxLinkLen {
+		r = io.TeeReader(r, &headWriter{e: e})
+	}
+	target, err := o.extractEntry(e.file, func() (io.ReadCloser, error) {
+		return io.NopCloser(r), nil
+	})
+	if err != nil {
+		return err
+	}
+	e.target = target
+	if o.After != nil {
+		o.After(e.file, target)
+	}
+	return nil
+}
+
+// verifyStream checks that every local file header is in the central directory.
+func verifyStream(entries []*streamEntry, records []*directoryRecord) error {
+	if len(entries) != len(records) {
+		return fmt.Errorf("%w: %d local file headers, %d central directory headers",
+			ErrStreamMismatch, len(entries), len(records))
+	}
+
+	offsets := make(map[uint64]*streamEntry, len(entries))
+	for _, e := range entries {
+		offsets[e.offset] = e
+	}
+
+	for _, r := range records {
+		e, ok := offsets[r.offset]
+		if !ok {
+			return fmt.Errorf("%w: %q has no local file header at offset %d", ErrStreamMismatch, r.file.Name, r.offset)
+		}
+		if e.file.Name != r.file.Name ||
+			e.file.CRC32 != r.file.CRC32 ||
+			e.file.CompressedSize64 != r.file.CompressedSize64 ||
+			e.file.UncompressedSize64 != r.file.UncompressedSize64 {
+			return fmt.Errorf("%w: %q", ErrStreamMismatch, r.file.Name)
+		}
+	}
+	return nil
+}
+
+// applyDirectory sets the file modes and creates the symbolic links recorded in the
+// central directory, which are unknown while reading the local file headers.
+func (o *ExtractOptions) applyDirectory(entries []*streamEntry, records []*directoryRecord) error {
+	offsets := make(map[uint64]*streamEntry, len(entries))
+	for _, e := range entries {
+		offsets[e.offset] = e
+	}
+
+	// regular files by name, that hard links are created of
+	var files map[string]*streamEntry
+	if o.HardLinks {
+		files = make(map[string]*streamEntry)
+		for _, r := range records {
+			if e := offsets[r.offset]; e.target != nil && r.file.Mode().IsRegular() {
+				if _, ok := FileHardLink(r.file); !ok {
+					files[r.file.Name] = e
+				}
+			}
+		}
+	}
+
+	var dirs []*directoryRecord
+	for _, r := range records {
+		e := offsets[r.offset]
+		if e.target == nil {
+			continue
+		}
+		mode := r.file.Mode()
+		if name, ok := FileHardLink(r.file); ok && files != nil && mode.IsRegular() {
+			// replace the copy of the file
+			if first, ok := files[name]; ok {
+				if err := o.link(first.target.Path, e.target.Path); err != nil {
+					return fmt.Errorf("hard link %q: %w", e.target.Path, err)
+				}
+				e.target.HardLink = first.target.Path
+				continue
+			}
+		}
+		switch {
+		case mode.IsDir():
+			// after files, read-only directories can not be written
+			dirs = append(dirs, r)
+		case IsSymlink(mode):
+			if len(e.head) > maxLinkLen {
+				return fmt.Errorf("link %q: target is too long", e.target.Path)
+			}
+			if err := o.fs().Remove(e.target.Path); err != nil {
+				return err
+			}
+			e.target.Symlink = string(e.head)
+			if err := o.fs().Symlink(e.target.Symlink, e.target.Path); err != nil {
+				return err
+			}
+			if err := o.restoreOwner(e.target.Path, r.file); err != nil {
+				return err
+			}
+			if err := o.restoreXattrs(e.target.Path, r.file); err != nil {
+				return err
+			}
+		case mode.Perm() != 0:
+			if err := o.fs().Chmod(e.target.Path, mode.Perm()); err != nil {
+				return fmt.Errorf("chmod file %q: %w", e.target.Path, err)
+			}
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		r := dirs[i]
+		target := offsets[r.offset].target
+		if err := o.fs().Chmod(target.Path, r.file.Mode().Perm()); err != nil {
+			return fmt.Errorf("chmod directory %q: %w", target.Path, err)
+		}
+		if err := o.restoreTimes(target.Path, r.file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxLinkLen is the longest target of symbolic links extracted from a stream.
+const maxLinkLen = 4096
+
+// headWriter keeps the first maxLinkLen+1 bytes written to it in e.head.
+type headWriter struct {
+	e *streamEntry
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if n := maxLinkLen + 1 - len(w.e.head); n > 0 {
+		w.e.head = append(w.e.head, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// streamReader reads an archive sequentially, counting the offset.
+type streamReader struct {
+	br     *bufio.Reader
+	offset uint64
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	n, err := s.br.Read(p)
+	s.offset += uint64(n)
+	return n, err
+}
+
+func (s *streamReader) ReadByte() (byte, error) {
+	b, err := s.br.ReadByte()
+	if err == nil {
+		s.offset++
+	}
+	return b, err
+}
+
+func (s *streamReader) discard(n uint64) error {
+	_, err := io.CopyN(io.Discard, s, int64(n))
+	return err
+}
+
+func (s *streamReader) uint32() (uint32, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(s, buf[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(buf[:]), nil
+}
+
+func (s *streamReader) readLocalHeader(offset uint64) (*streamEntry, error) {
+	// reference: https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT
+	// 4.3.7  Local file header:
+	var buf [fileHeaderLen - 4]byte
+	if _, err := io.ReadFull(s, buf[:]); err != nil {
+		return nil, fmt.Errorf("read local file header at offset %d: %w", offset, err)
+	}
+	b := readBuf(buf[:])
+	file := &File{}
+	file.ReaderVersion = b.uint16()
+	file.Flags = b.uint16()
+	file.Method = b.uint16()
+	file.ModifiedTime = b.uint16()
+	file.ModifiedDate = b.uint16()
+	file.CRC32 = b.uint32()
+	file.CompressedSize = b.uint32()
+	file.UncompressedSize = b.uint32()
+	file.CompressedSize64 = uint64(file.CompressedSize)
+	file.UncompressedSize64 = uint64(file.UncompressedSize)
+	nameLen := int(b.uint16())
+	extraLen := int(b.uint16())
+
+	d := make([]byte, nameLen+extraLen)
+	if _, err := io.ReadFull(s, d); err != nil {
+		return nil, fmt.Errorf("read local file header at offset %d: %w", offset, err)
+	}
+	file.Name = string(d[:nameLen])
+	file.Extra = d[nameLen:]
+	file.Modified = msDosTimeToTime(file.ModifiedDate, file.ModifiedTime)
+
+	e := &streamEntry{file: file, offset: offset}
+	err := parseExtra(file.Extra, func(id uint16, field readBuf) error {
+		switch id {
+		case zip64ExtraID:
+			e.zip64 = true
+			if file.UncompressedSize == uint32max {
+				if len(field) < 8 {
+					return ErrFormat
+				}
+				file.UncompressedSize64 = field.uint64()
+			}
+			if file.CompressedSize == uint32max {
+				if len(field) < 8 {
+					return ErrFormat
+				}
+				file.CompressedSize64 = field.uint64()
+			}
+		case extTimeExtraID:
+			if len(field) >= 5 && field.uint8()&1 != 0 {
+				file.Modified = time.Unix(int64(field.uint32()), 0)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read extra of %q: %w", file.Name, err)
+	}
+	return e, nil
+}
+
+// readDataDescriptor reads a data descriptor with or without its signature.
+func (s *streamReader) readDataDescriptor(zip64 bool) (crc uint32, compressedSize, size uint64, err error) {
+	// reference: https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT
+	// 4.3.9  Data descriptor:
+	sig, err := s.br.Peek(4)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if binary.LittleEndian.Uint32(sig) == dataDescriptorSignature {
+		if err = s.discard(4); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	var buf [dataDescriptor64Len - 4]byte
+	n := dataDescriptorLen - 4
+	if zip64 {
+		n = dataDescriptor64Len - 4
+	}
+	if _, err = io.ReadFull(s, buf[:n]); err != nil {
+		return 0, 0, 0, err
+	}
+	b := readBuf(buf[:n])
+	crc = b.uint32()
+	if zip64 {
+		return crc, b.uint64(), b.uint64(), nil
+	}
+	return crc, uint64(b.uint32()), uint64(b.uint32()), nil
+}
+
+// readDirectory reads the central directory and the end of central directory
+// records, the signature of the first header has been read.
+func (s *streamReader) readDirectory() ([]*directoryRecord, error) {
+	var records []*directoryRecord
+	for {
+		r, err := readDirectoryHeader(s)
+		if err != nil {
+			return records, err
+		}
+		records = append(records, r)
+
+		sig, err := s.uint32()
+		if err != nil {
+			return records, fmt.Errorf("read central directory: %w", err)
+		}
+
+		switch sig {
+		case directoryHeaderSignature:
+			continue
+		case directory64EndSignature, directoryEndSignature:
+			// the end records are not needed, the directory has been read.
+			_, err = io.Copy(io.Discard, s)
+			return records, err
+		default:
+			return records, fmt.Errorf("%w: unexpected signature %#08x in central directory", ErrFormat, sig)
+		}
+	}
+}
+
+// readDirectoryHeader reads a central directory header after its signature.
+func readDirectoryHeader(r io.Reader) (*directoryRecord, error) {
+	// reference: https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT
+	// 4.3.12  Central directory structure:
+	var buf [directoryHeaderLen - 4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, fmt.Errorf("read central directory: %w", err)
+	}
+	b := readBuf(buf[:])
+	file := &File{}
+	file.CreatorVersion = b.uint16()
+	file.ReaderVersion = b.uint16()
+	file.Flags = b.uint16()
+	file.Method = b.uint16()
+	file.ModifiedTime = b.uint16()
+	file.ModifiedDate = b.uint16()
+	file.CRC32 = b.uint32()
+	file.CompressedSize = b.uint32()
+	file.UncompressedSize = b.uint32()
+	file.CompressedSize64 = uint64(file.CompressedSize)
+	file.UncompressedSize64 = uint64(file.UncompressedSize)
+	nameLen := int(b.uint16())
+	extraLen := int(b.uint16())
+	commentLen := int(b.uint16())
+	disk16 := b.uint16()
+	b = b[2:] // skip internal file attributes
+	file.ExternalAttrs = b.uint32()
+	offset32 := b.uint32()
+
+	d := make([]byte, nameLen+extraLen+commentLen)
+	if _, err := io.ReadFull(r, d); err != nil {
+		return nil, fmt.Errorf("read central directory: %w", err)
+	}
+	file.Name = string(d[:nameLen])
+	file.Extra = d[nameLen : nameLen+extraLen]
+	file.Comment = string(d[nameLen+extraLen:])
+	file.Modified = msDosTimeToTime(file.ModifiedDate, file.ModifiedTime)
+
+	record := &directoryRecord{file: file, disk: uint32(disk16), offset: uint64(offset32)}
+	err := parseExtra(file.Extra, func(id uint16, field readBuf) error {
+		if id != zip64ExtraID {
+			return nil
+		}
+		// 4.5.3 only the fields that overflow are present, in this order.
+		for _, v := range []struct {
+			overflow bool
+			set      func(readBuf)
+		}{
+			{file.UncompressedSize == uint32max, func(f readBuf) { file.UncompressedSize64 = f.uint64() }},
+			{file.CompressedSize == uint32max, func(f readBuf) { file.CompressedSize64 = f.uint64() }},
+			{offset32 == uint32max, func(f readBuf) { record.offset = f.uint64() }},
+		} {
+			if !v.overflow {
+				continue
+			}
+			if len(field) < 8 {
+				return ErrFormat
+			}
+			v.set(field)
+			field = field[8:]
+		}
+		if disk16 == uint16max {
+			if len(field) < 4 {
+				return ErrFormat
+			}
+			record.disk = field.uint32()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read extra of %q: %w", file.Name, err)
+	}
+	return record, nil
+}
+
+// parseExtra calls fn with each field of extra.
+func parseExtra(extra []byte, fn func(id uint16, field readBuf) error) error {
+	b := readBuf(extra)
+	for len(b) >= 4 {
+		id := b.uint16()
+		size := int(b.uint16())
+		if len(b) < size {
+			return ErrFormat
+		}
+		if err := fn(id, b.sub(size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storedDescriptorReader reads stored data of unknown size, which ends at a data
+// descriptor whose crc32 and sizes match the data read so far.
+type storedDescriptorReader struct {
+	s     *streamReader
+	hash  hash.Hash32
+	n     uint64
+	zip64 bool
+	eof   bool
+}
+
+func (r *storedDescriptorReader) Read(p []byte) (int, error) {
+	if r.eof {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	peek, err := r.s.br.Peek(streamReadSize)
+	if len(peek) == 0 {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+
+	var sig [4]byte
+	binary.LittleEndian.PutUint32(sig[:], dataDescriptorSignature)
+	i := bytes.Index(peek, sig[:])
+	switch {
+	case i == 0:
+		if r.isDescriptor() {
+			r.eof = true
+			return 0, io.EOF
+		}
+		// not a descriptor, part of the data
+		i = 1
+	case i < 0:
+		// the signature may be split at the end of peek
+		i = max(len(peek)-len(sig)+1, 1)
+		if err == io.EOF {
+			i = len(peek)
+		}
+	}
+
+	n, err := r.s.Read(p[:min(i, len(p))])
+	r.hash.Write(p[:n])
+	r.n += uint64(n)
+	return n, err
+}
+
+// isDescriptor reports whether the data descriptor at the current offset
+// matches the data read so far.
+func (r *storedDescriptorReader) isDescriptor() bool {
+	buf, _ := r.s.br.Peek(dataDescriptor64Len)
+	if len(buf) < dataDescriptorLen {
+		return false
+	}
+	b := readBuf(buf[4:])
+	if b.uint32() != r.hash.Sum32() {
+		return false
+	}
+	if r.zip64 || r.n >= uint32max {
+		return len(b) >= 16 && b.uint64() == r.n && b.uint64() == r.n
+	}
+	return b.uint32() == uint32(r.n) && b.uint32() == uint32(r.n)
+}
+
+// checksumReader computes the crc32 and size of what is read, if file is not nil
+// they are checked against it at EOF.
+type checksumReader struct {
+	rc   io.ReadCloser
+	hash hash.Hash32
+	n    uint64
+	file *File
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.hash.Write(p[:n])
+	r.n += uint64(n)
+	if err == io.EOF && r.file != nil {
+		if r.n != r.file.UncompressedSize64 || r.hash.Sum32() != r.file.CRC32 {
+			return n, fmt.Errorf("%q: %w", r.file.Name, ErrChecksum)
+		}
+	}
+	return n, err
+}
+
+type countReader struct {
+	r *streamReader
+	n uint64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += uint64(n)
+	return n, err
+}
+
+func (r *countReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return b, err
+}
+
+type readBuf []byte
+
+func (b *readBuf) uint8() uint8 {
+	v := (*b)[0]
+	*b = (*b)[1:]
+	return v
+}
+
+func (b *readBuf) uint16() uint16 {
+	v := binary.LittleEndian.Uint16(*b)
+	*b = (*b)[2:]
+	return v
+}
+
+func (b *readBuf) uint32() uint32 {
+	v := binary.LittleEndian.Uint32(*b)
+	*b = (*b)[4:]
+	return v
+}
+
+func (b *readBuf) uint64() uint64 {
+	v := binary.LittleEndian.Uint64(*b)
+	*b = (*b)[8:]
+	return v
+}
+
+func (b *readBuf) sub(n int) readBuf {
+	b2 := (*b)[:n]
+	*b = (*b)[n:]
+	return b2
+}
+
+// msDosTimeToTime converts an MS-DOS date and time into a time.Time.
+// The resolution is 2s.
+// See: https://learn.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-dosdatetimetofiletime
+func msDosTimeToTime(dosDate, dosTime uint16) time.Time {
+	return time.Date(
+		// date bits 0-4: day of month; 5-8: month; 9-15: years since 1980
+		int(dosDate>>9+1980),
+		time.Month(dosDate>>5&0xf),
+		int(dosDate&0x1f),
+
+		// time bits 0-4: second/2; 5-10: minute; 11-15: hour
+		int(dosTime>>11),
+		int(dosTime>>5&0x3f),
+		int(dosTime&0x1f*2),
+		0, // nanoseconds
+
+		time.UTC,
+	)
+}
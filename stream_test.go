@@ -0,0 +1,112 @@
+package pzip
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractStream(t *testing.T) {
+	files := map[string]string{
+		"a.txt":       "hello",
+		"dir/b.txt":   strings.Repeat("hello world\n", 1000),
+		"dir/c/d.md":  "# hello",
+		"stdin.log":   strings.Repeat("GET / 200\n", 1000),
+		"skipped.txt": "skipped",
+	}
+	buf := new(bytes.Buffer)
+	err := ArchiveTo(context.Background(), buf, &ArchiveOptions{
+		Concurrency: 2,
+		Level:       -1,
+		Entries: []Entry{
+			{Name: "a.txt", Size: 5, Mode: 0600, Open: openString(files["a.txt"])},
+			{Name: "dir/", Mode: os.ModeDir | 0750},
+			{Name: "dir/b.txt", Size: int64(len(files["dir/b.txt"])), Mode: 0644, Open: openString(files["dir/b.txt"])},
+			{Name: "dir/c/d.md", Size: 7, Mode: 0644, Open: openString(files["dir/c/d.md"])},
+			{Name: "dir/link", Size: 5, Mode: os.ModeSymlink | 0777, Open: openString("b.txt")},
+			{Name: "stdin.log", Size: -1, Mode: 0644, Reader: strings.NewReader(files["stdin.log"])},
+			{Name: "skipped.txt", Size: 7, Mode: 0644, Open: openString(files["skipped.txt"])},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	err = ExtractStream(context.Background(), io.MultiReader(bytes.NewReader(buf.Bytes())), &ExtractOptions{
+		OutDir:      out,
+		Concurrency: 2,
+		SkipPath:    SkipPath{Excludes: []string{"skipped.txt"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		b, err := os.ReadFile(filepath.Join(out, name))
+		if name == "skipped.txt" {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s: want skipped, got %v", name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Errorf("%s: got %q, want %q", name, b, content)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(out, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("a.txt: got mode %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+	link, err := os.Readlink(filepath.Join(out, "dir/link"))
+	if err != nil || link != "b.txt" {
+		t.Errorf("dir/link: got %q, %v, want b.txt", link, err)
+	}
+
+	// corrupt the crc32 of the first central directory header
+	data := bytes.Clone(buf.Bytes())
+	var sig [4]byte
+	binary.LittleEndian.PutUint32(sig[:], directoryHeaderSignature)
+	i := bytes.Index(data, sig[:])
+	data[i+16]++
+	err = ExtractStream(context.Background(), bytes.NewReader(data), &ExtractOptions{
+		OutDir:      t.TempDir(),
+		Concurrency: 1,
+	})
+	if !errors.Is(err, ErrStreamMismatch) {
+		t.Errorf("got %v, want %v", err, ErrStreamMismatch)
+	}
+
+	// the archive of excluded files has only the end of central directory record
+	buf.Reset()
+	err = ArchiveTo(context.Background(), buf, &ArchiveOptions{
+		Concurrency: 1,
+		Entries:     []Entry{{Name: "a.txt", Size: 5, Mode: 0644, Open: openString("hello")}},
+		SkipPath:    SkipPath{Excludes: []string{"*.txt"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ExtractStream(context.Background(), buf, &ExtractOptions{OutDir: t.TempDir(), Concurrency: 1}); err != nil {
+		t.Errorf("empty archive: %v", err)
+	}
+}
+
+func openString(s string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(s)), nil
+	}
+}
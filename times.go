@@ -0,0 +1,104 @@
+package pzip
+
+import "time"
+
+// ntfsEpochOffset is the seconds from the NTFS epoch 1601-01-01 to the Unix epoch,
+// NTFS times count 100ns intervals from it.
+const ntfsEpochOffset = 11644473600
+
+// timeExtra returns the extended timestamp extra field of mtime, with atime and
+// ctime if not zero. If ntfs is set, it is followed by the NTFS extra field
+// with 100ns precision, which readers prefer as the last one.
+func timeExtra(mtime, atime, ctime time.Time, ntfs bool) []byte {
+	// Use "extended timestamp" format since this is what Info-ZIP uses.
+	// Nearly every major ZIP implementation uses a different format,
+	// but at least most seem to be able to understand the other formats.
+	//
+	// The central header has the same field as the local header, the
+	// access and creation times are ignored by readers of the central one.
+	var buf [17 + 36]byte
+	b := writeBuf(buf[:])
+	flags, size := uint8(1), 5
+	if !atime.IsZero() {
+		flags |= 2
+		size += 4
+	}
+	if !ctime.IsZero() {
+		flags |= 4
+		size += 4
+	}
+	b.uint16(extTimeExtraID)
+	b.uint16(uint16(size))
+	b.uint8(flags)
+	for _, t := range []time.Time{mtime, atime, ctime} {
+		if !t.IsZero() {
+			b.uint32(uint32(t.Unix()))
+		}
+	}
+	n := 4 + size
+
+	if ntfs {
+		if atime.IsZero() {
+			atime = mtime
+		}
+		if ctime.IsZero() {
+			ctime = mtime
+		}
+		b.uint16(ntfsExtraID)
+		b.uint16(32)
+		b.uint32(0)  // reserved
+		b.uint16(1)  // attribute tag of the times
+		b.uint16(24) // attribute size
+		for _, t := range []time.Time{mtime, atime, ctime} {
+			b.uint64(uint64((t.Unix()+ntfsEpochOffset)*1e7 + int64(t.Nanosecond()/100)))
+		}
+		n += 36
+	}
+	return buf[:n]
+}
+
+// FileTimes returns the modification and access times of f, from the NTFS
+// extra field for its precision, the extended timestamp, or the Unix fields.
+// atime is zero if it is not recorded.
+func FileTimes(f *File) (mtime, atime time.Time) {
+	var ntfs bool
+	_ = parseExtra(f.Extra, func(id uint16, field readBuf) error {
+		switch {
+		case id == ntfsExtraID && len(field) >= 4:
+			field = field[4:] // reserved
+			for len(field) >= 4 {
+				tag, size := field.uint16(), int(field.uint16())
+				if len(field) < size {
+					break
+				}
+				attr := field.sub(size)
+				if tag == 1 && size == 24 {
+					mtime = ntfsTime(attr.uint64())
+					atime = ntfsTime(attr.uint64())
+					ntfs = true
+				}
+			}
+		case ntfs:
+		case id == extTimeExtraID && len(field) >= 1:
+			flags := field.uint8()
+			if flags&1 != 0 && len(field) >= 4 {
+				mtime = time.Unix(int64(field.uint32()), 0)
+			}
+			if flags&2 != 0 && len(field) >= 4 {
+				atime = time.Unix(int64(field.uint32()), 0)
+			}
+		case (id == unixExtraID || id == infoZipUnixExtraID) && len(field) >= 8 && mtime.IsZero():
+			atime = time.Unix(int64(field.uint32()), 0)
+			mtime = time.Unix(int64(field.uint32()), 0)
+		}
+		return nil
+	})
+	if mtime.IsZero() {
+		mtime = f.Modified
+	}
+	return mtime, atime
+}
+
+func ntfsTime(t uint64) time.Time {
+	return time.Unix(int64(t/1e7)-ntfsEpochOffset, int64(t%1e7)*100)
+}
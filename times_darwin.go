@@ -0,0 +1,17 @@
+package pzip
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// statTimes returns the access and creation times of the file at path, whose
+// info is of the OS filesystem. They are zero if unknown.
+func statTimes(path string, info fs.FileInfo) (atime, ctime time.Time) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return atime, ctime
+	}
+	return time.Unix(st.Atimespec.Unix()), time.Unix(st.Birthtimespec.Unix())
+}
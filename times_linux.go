@@ -0,0 +1,29 @@
+package pzip
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// statTimes returns the access and creation times of the file at path, whose
+// info is of the OS filesystem. They are zero if unknown.
+func statTimes(path string, info fs.FileInfo) (atime, ctime time.Time) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return atime, ctime
+	}
+	atime = time.Unix(st.Atim.Unix())
+
+	flags := 0
+	if IsSymlink(info.Mode()) {
+		flags = unix.AT_SYMLINK_NOFOLLOW
+	}
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, flags, unix.STATX_BTIME, &stx); err == nil && stx.Mask&unix.STATX_BTIME != 0 {
+		ctime = time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec))
+	}
+	return atime, ctime
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !windows
+
+package pzip
+
+import (
+	"io/fs"
+	"time"
+)
+
+// statTimes returns zero times, they are unknown on this system.
+func statTimes(path string, info fs.FileInfo) (atime, ctime time.Time) {
+	return atime, ctime
+}
@@ -0,0 +1,67 @@
+package pzip
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTimeExtra(t *testing.T) {
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 123456700, time.UTC)
+	atime := mtime.Add(time.Hour + 500*time.Millisecond)
+	for _, ntfs := range []bool{false, true} {
+		f := new(File)
+		f.Extra = timeExtra(mtime, atime, time.Time{}, ntfs)
+		gotMtime, gotAtime := FileTimes(f)
+
+		wantMtime, wantAtime := mtime.Truncate(time.Second), atime.Truncate(time.Second)
+		if ntfs {
+			wantMtime, wantAtime = mtime, atime
+		}
+		if !gotMtime.Equal(wantMtime) || !gotAtime.Equal(wantAtime) {
+			t.Errorf("ntfs %v: got %v, %v, want %v, %v", ntfs, gotMtime, gotAtime, wantMtime, wantAtime)
+		}
+	}
+}
+
+func TestExtractFrom_Times(t *testing.T) {
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 123456700, time.Local)
+	buf := new(bytes.Buffer)
+	err := ArchiveTo(context.Background(), buf, &ArchiveOptions{
+		Concurrency: 2,
+		ExtraTimes:  true,
+		Entries: []Entry{
+			{Name: "dir/", Mode: fs.ModeDir | 0755, Modified: mtime},
+			{Name: "dir/a.txt", Size: 5, Mode: 0644, Modified: mtime.Add(time.Second), Open: openString("hello")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stream := range []bool{false, true} {
+		out := t.TempDir()
+		opts := &ExtractOptions{Concurrency: 2, OutDir: out}
+		if stream {
+			err = ExtractStream(context.Background(), bytes.NewReader(buf.Bytes()), opts)
+		} else {
+			err = ExtractFrom(context.Background(), bytes.NewReader(buf.Bytes()), int64(buf.Len()), opts)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range map[string]time.Time{"dir": mtime, "dir/a.txt": mtime.Add(time.Second)} {
+			info, err := os.Stat(filepath.Join(out, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.ModTime().Equal(want) {
+				t.Errorf("stream %v: %s: got %v, want %v", stream, name, info.ModTime(), want)
+			}
+		}
+	}
+}
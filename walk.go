@@ -0,0 +1,263 @@
+package pzip
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// maxWalkAhead is the most directories listed ahead of the walk by each walker.
+const maxWalkAhead = 64
+
+type byInode struct {
+	entries []fs.DirEntry
+	inodes  []uint64
+}
+
+func (b byInode) Len() int           { return len(b.entries) }
+func (b byInode) Less(i, j int) bool { return b.inodes[i] < b.inodes[j] }
+func (b byInode) Swap(i, j int) {
+	b.entries[i], b.entries[j] = b.entries[j], b.entries[i]
+	b.inodes[i], b.inodes[j] = b.inodes[j], b.inodes[i]
+}
+
+// walkByInode walks the tree at root like filepath.WalkDir, but the entries
+// of each directory are in the order of their inodes, which is closer to the
+// order of their data on disk than their names for most filesystems.
+func walkByInode(root string, fn fs.WalkDirFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkInodeDir(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkInodeDir(path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		// report the error of reading the directory
+		if err = fn(path, d, err); err != nil {
+			if err == filepath.SkipDir && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	inodes := make([]uint64, len(entries))
+	for i, entry := range entries {
+		if info, err := entry.Info(); err == nil {
+			if id, _, ok := fileIdentity(info); ok {
+				inodes[i] = id.ino
+			}
+		}
+	}
+	sort.Stable(byInode{entries, inodes})
+
+	for _, entry := range entries {
+		if err = walkInodeDir(filepath.Join(path, entry.Name()), entry, fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// walkDir is a directory listed by the walkers, once.
+type walkDir struct {
+	path    string
+	once    sync.Once
+	entries []walkEntry
+	err     error
+}
+
+// walkEntry is an entry of a directory, whose info is read by the walkers.
+type walkEntry struct {
+	fs.DirEntry
+	info fs.FileInfo
+	err  error
+	// dir is the listing of the entry if it is a directory.
+	dir *walkDir
+}
+
+func (e *walkEntry) Info() (fs.FileInfo, error) {
+	return e.info, e.err
+}
+
+// parallelWalk lists directories and reads the info of their entries with
+// several walkers, ahead of the walk that calls fn in the order of
+// filepath.WalkDir, or of walkByInode if byInode is set.
+type parallelWalk struct {
+	byInode bool
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// pending are the directories found and not listed yet, the last one
+	// is listed first, ahead is the number listed and not walked yet.
+	pending  []*walkDir
+	ahead    int
+	maxAhead int
+	stopped  bool
+}
+
+// walkParallel walks the tree at root like filepath.WalkDir with walkers
+// listing directories in parallel, the order of fn calls is the same.
+func walkParallel(root string, walkers int, byInode bool, fn fs.WalkDirFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		w := &parallelWalk{byInode: byInode, maxAhead: walkers * maxWalkAhead}
+		w.cond = sync.NewCond(&w.mu)
+		var wg sync.WaitGroup
+		for i := 0; i < walkers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w.run()
+			}()
+		}
+		entry := &walkEntry{DirEntry: fs.FileInfoToDirEntry(info), info: info}
+		if info.IsDir() {
+			entry.dir = &walkDir{path: root}
+		}
+		err = w.walk(root, entry, fn)
+		w.stop()
+		wg.Wait()
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// run lists the pending directories until the walk stops.
+func (w *parallelWalk) run() {
+	for {
+		w.mu.Lock()
+		for !w.stopped && (len(w.pending) == 0 || w.ahead >= w.maxAhead) {
+			w.cond.Wait()
+		}
+		if w.stopped {
+			w.mu.Unlock()
+			return
+		}
+		dir := w.pending[len(w.pending)-1]
+		w.pending = w.pending[:len(w.pending)-1]
+		w.mu.Unlock()
+		dir.once.Do(func() { w.list(dir) })
+	}
+}
+
+func (w *parallelWalk) stop() {
+	w.mu.Lock()
+	w.stopped = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// list lists dir, reading the info of its entries, and adds its subdirectories
+// to the pending ones.
+func (w *parallelWalk) list(dir *walkDir) {
+	w.mu.Lock()
+	w.ahead++
+	w.mu.Unlock()
+
+	entries, err := os.ReadDir(dir.path)
+	dir.err = err
+	dir.entries = make([]walkEntry, len(entries))
+	inodes := make([]uint64, len(entries))
+	for i, entry := range entries {
+		e := &dir.entries[i]
+		e.DirEntry = entry
+		e.info, e.err = entry.Info()
+		if e.err == nil {
+			if id, _, ok := fileIdentity(e.info); ok {
+				inodes[i] = id.ino
+			}
+		}
+		if entry.IsDir() {
+			e.dir = &walkDir{path: filepath.Join(dir.path, entry.Name())}
+		}
+	}
+	if w.byInode {
+		sort.Stable(byInodeEntry{dir.entries, inodes})
+	}
+
+	w.mu.Lock()
+	// the first subdirectory is listed first
+	for i := len(dir.entries) - 1; i >= 0; i-- {
+		if dir.entries[i].dir != nil {
+			w.pending = append(w.pending, dir.entries[i].dir)
+		}
+	}
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// walk calls fn for the entry at path and the tree under it, listing the
+// directories the walkers have not listed yet.
+func (w *parallelWalk) walk(path string, e *walkEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, e, nil); err != nil || e.dir == nil {
+		if err == filepath.SkipDir && e.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	dir := e.dir
+	dir.once.Do(func() { w.list(dir) })
+	entries := dir.entries
+	dir.entries = nil
+	w.mu.Lock()
+	w.ahead--
+	w.cond.Broadcast()
+	w.mu.Unlock()
+
+	if dir.err != nil {
+		// report the error of reading the directory
+		if err := fn(path, e, dir.err); err != nil {
+			if err == filepath.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+	for i := range entries {
+		if err := w.walk(filepath.Join(path, entries[i].Name()), &entries[i], fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+type byInodeEntry struct {
+	entries []walkEntry
+	inodes  []uint64
+}
+
+func (b byInodeEntry) Len() int           { return len(b.entries) }
+func (b byInodeEntry) Less(i, j int) bool { return b.inodes[i] < b.inodes[j] }
+func (b byInodeEntry) Swap(i, j int) {
+	b.entries[i], b.entries[j] = b.entries[j], b.entries[i]
+	b.inodes[i], b.inodes[j] = b.inodes[j], b.inodes[i]
+}
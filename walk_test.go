@@ -0,0 +1,104 @@
+package pzip
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// makeTree creates dirs directories of files files each under a temporary directory.
+func makeTree(t *testing.T, dirs, files int) string {
+	root := t.TempDir()
+	for i := 0; i < dirs; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("d%d", i%7), fmt.Sprintf("e%d", i))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < files; j++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d.txt", j)), []byte(dir), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+func collectPaths(paths *[]string) func(path string, d os.DirEntry, err error) error {
+	return func(path string, d os.DirEntry, err error) error {
+		*paths = append(*paths, path)
+		if err == nil {
+			_, err = d.Info()
+		}
+		return err
+	}
+}
+
+func TestWalkParallel(t *testing.T) {
+	root := makeTree(t, 300, 3)
+	var want []string
+	if err := filepath.WalkDir(root, collectPaths(&want)); err != nil {
+		t.Fatal(err)
+	}
+	for _, walkers := range []int{1, 2, 8} {
+		var got []string
+		if err := walkParallel(root, walkers, false, collectPaths(&got)); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%d walkers: got %d paths not in the order of WalkDir", walkers, len(got))
+		}
+	}
+
+	var byInode, parallel []string
+	if err := walkByInode(root, collectPaths(&byInode)); err != nil {
+		t.Fatal(err)
+	}
+	if err := walkParallel(root, 4, true, collectPaths(&parallel)); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(byInode, parallel) {
+		t.Error("got paths not in the order of walkByInode")
+	}
+
+	// the walk stops at the first error
+	var paths []string
+	errStop := fmt.Errorf("stop")
+	err := walkParallel(root, 4, false, func(path string, d os.DirEntry, err error) error {
+		if paths = append(paths, path); len(paths) == 10 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || !slices.Equal(paths, want[:10]) {
+		t.Errorf("got %v after %d paths", err, len(paths))
+	}
+
+	if err = walkParallel(filepath.Join(root, "missing"), 4, false, collectPaths(&paths)); !os.IsNotExist(err) {
+		t.Errorf("got %v, want not exist", err)
+	}
+}
+
+func TestWalkByInode(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"c", "a/x", "b", "a/y"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got, want []string
+	if err := walkByInode(dir, collectPaths(&got)); err != nil {
+		t.Fatal(err)
+	}
+	if err := filepath.WalkDir(dir, collectPaths(&want)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) || got[0] != dir {
+		t.Errorf("got %v, want the paths of %v", got, want)
+	}
+}
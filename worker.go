@@ -89,6 +89,12 @@ func (fw *FailFastWorker[T]) exec() error {
 	}
 }
 
+// Done returns a channel that is closed when a task fails or the context of
+// the worker is done.
+func (fw *FailFastWorker[T]) Done() <-chan struct{} {
+	return fw.ctx.Done()
+}
+
 // Len returns the number of tasks that are waiting to be processed
 func (fw *FailFastWorker[T]) Len() int {
 	return len(fw.tasks)
@@ -110,6 +116,16 @@ func (fw *FailFastWorker[T]) Wait() error {
 	return fw.err
 }
 
+// Drain calls fn with the tasks that are not processed, after Wait.
+func (fw *FailFastWorker[T]) Drain(fn func(*T)) {
+	if !fw.IsClosed() {
+		return
+	}
+	for t := range fw.tasks {
+		fn(t)
+	}
+}
+
 // IsClosed indicates whether the worker is closed.
 func (fw *FailFastWorker[T]) IsClosed() bool {
 	return atomic.LoadInt32(&fw.state) == CLOSED
@@ -121,10 +137,6 @@ func (fw *FailFastWorker[T]) IsOpened() bool {
 }
 
 func (fw *FailFastWorker[T]) Submit(task *T) error {
-	if fw.err != nil {
-		return fw.err
-	}
-
 	if !fw.IsOpened() {
 		return ErrWorkerNotOpened
 	}
@@ -133,14 +145,17 @@ func (fw *FailFastWorker[T]) Submit(task *T) error {
 		return ErrWorkerClosed
 	}
 
+	// the error of a failed task is the cause of the canceled context, fw.err
+	// is only read after Wait
+	if err := context.Cause(fw.ctx); err != nil {
+		return err
+	}
+
 	select {
 	case fw.tasks <- task:
 		// Task submitted successfully
 	case <-fw.ctx.Done():
-		if fw.err != nil {
-			return fw.err
-		}
-		return fw.ctx.Err()
+		return context.Cause(fw.ctx)
 	}
 	return nil
 }
@@ -0,0 +1,515 @@
+package pzip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriteFS is a filesystem files are extracted to, names are OS paths.
+type WriteFS interface {
+	// MkdirAll creates a directory named name, along with any necessary parents.
+	MkdirAll(name string, perm fs.FileMode) error
+	// Mkdir creates a directory, the error satisfies errors.Is(err, fs.ErrExist)
+	// if it already exists.
+	Mkdir(name string, perm fs.FileMode) error
+	Chmod(name string, mode fs.FileMode) error
+	// OpenFile opens a file for writing with flag of os.O_CREATE, os.O_TRUNC and os.O_WRONLY.
+	OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error)
+	Symlink(oldname, newname string) error
+	Remove(name string) error
+}
+
+// ChownFS is a WriteFS that can change the owner of files, without following
+// symbolic links.
+type ChownFS interface {
+	WriteFS
+	Lchown(name string, uid, gid int) error
+}
+
+// ChtimesFS is a WriteFS that can change the access and modification times of files.
+type ChtimesFS interface {
+	WriteFS
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// XattrFS is a WriteFS that can set extended attributes of files, without
+// following symbolic links.
+type XattrFS interface {
+	WriteFS
+	Lsetxattr(name, attr string, value []byte) error
+}
+
+// LinkFS is a WriteFS that can create hard links.
+type LinkFS interface {
+	WriteFS
+	Link(oldname, newname string) error
+}
+
+// OSFS is the WriteFS of the operating system.
+type OSFS struct{}
+
+func (OSFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (OSFS) Mkdir(name string, perm fs.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (OSFS) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (OSFS) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (OSFS) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) Lchown(name string, uid, gid int) error {
+	return os.Lchown(name, uid, gid)
+}
+
+func (OSFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (OSFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (OSFS) Lsetxattr(name, attr string, value []byte) error {
+	return lsetxattr(name, attr, value)
+}
+
+// RootFS is a WriteFS of the OS directory Root, names resolved outside of it are
+// rejected, either by ".." or by symbolic links extracted before.
+type RootFS struct {
+	OSFS
+	Root string
+}
+
+// NewRootFS returns a RootFS of dir.
+func NewRootFS(dir string) (*RootFS, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, err
+	}
+	return &RootFS{Root: root}, nil
+}
+
+// resolve returns the OS path of name, checking that it does not escape the root.
+func (r *RootFS) resolve(name string) (string, error) {
+	if filepath.IsAbs(name) || !filepath.IsLocal(name) && filepath.Clean(name) != "." {
+		return "", &fs.PathError{Op: "resolve", Path: name, Err: ErrInsecurePath}
+	}
+
+	full := filepath.Join(r.Root, name)
+	// the nearest existing parent must not be a link out of the root
+	for dir := filepath.Dir(full); ; dir = filepath.Dir(dir) {
+		real, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			if real != r.Root && !strings.HasPrefix(real, r.Root+string(filepath.Separator)) {
+				return "", &fs.PathError{Op: "resolve", Path: name, Err: ErrInsecurePath}
+			}
+			return full, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) || dir == r.Root {
+			return "", err
+		}
+	}
+}
+
+func (r *RootFS) MkdirAll(name string, perm fs.FileMode) error {
+	full, err := r.resolve(name)
+	if err != nil {
+		return err
+	}
+	return r.OSFS.MkdirAll(full, perm)
+}
+
+func (r *RootFS) Mkdir(name string, perm fs.FileMode) error {
+	full, err := r.resolve(name)
+	if err != nil {
+		return err
+	}
+	return r.OSFS.Mkdir(full, perm)
+}
+
+func (r *RootFS) Chmod(name string, mode fs.FileMode) error {
+	full, err := r.resolve(name)
+	if err != nil {
+		return err
+	}
+	return r.OSFS.Chmod(full, mode)
+}
+
+func (r *RootFS) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	full, err := r.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	// do not write through a link extracted at name
+	if info, err := os.Lstat(full); err == nil && IsSymlink(info.Mode()) {
+		if err = os.Remove(full); err != nil {
+			return nil, err
+		}
+	}
+	return r.OSFS.OpenFile(full, flag, perm)
+}
+
+func (r *RootFS) Symlink(oldname, newname string) error {
+	full, err := r.resolve(newname)
+	if err != nil {
+		return err
+	}
+	return r.OSFS.Symlink(oldname, full)
+}
+
+func (r *RootFS) Remove(name string) error {
+	full, err := r.resolve(name)
+	if err != nil {
+		return err
+	}
+	return r.OSFS.Remove(full)
+}
+
+func (r *RootFS) Lchown(name string, uid, gid int) error {
+	full, err := r.resolve(name)
+	if err != nil {
+		return err
+	}
+	return r.OSFS.Lchown(full, uid, gid)
+}
+
+func (r *RootFS) Chtimes(name string, atime, mtime time.Time) error {
+	full, err := r.resolve(name)
+	if err != nil {
+		return err
+	}
+	return r.OSFS.Chtimes(full, atime, mtime)
+}
+
+func (r *RootFS) Link(oldname, newname string) error {
+	oldFull, err := r.resolve(oldname)
+	if err != nil {
+		return err
+	}
+	newFull, err := r.resolve(newname)
+	if err != nil {
+		return err
+	}
+	return r.OSFS.Link(oldFull, newFull)
+}
+
+func (r *RootFS) Lsetxattr(name, attr string, value []byte) error {
+	full, err := r.resolve(name)
+	if err != nil {
+		return err
+	}
+	return r.OSFS.Lsetxattr(full, attr, value)
+}
+
+// MemFS is an in-memory WriteFS, it is also an fs.FS to read back extracted files.
+type MemFS struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+}
+
+type memFile struct {
+	name    string
+	mode    fs.FileMode
+	modTime time.Time
+	data    []byte
+	uid     int
+	gid     int
+	xattrs  map[string][]byte
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: map[string]*memFile{
+			".": {name: ".", mode: fs.ModeDir | 0755, modTime: time.Now()},
+		},
+	}
+}
+
+// memName returns the fs.FS name of an OS path.
+func memName(name string) string {
+	return strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "/")
+}
+
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = memName(name)
+	for dir := name; dir != "." && dir != ""; dir = path.Dir(dir) {
+		if f, ok := m.files[dir]; ok {
+			if !f.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+			}
+			break
+		}
+		m.files[dir] = &memFile{name: dir, mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *MemFS) Mkdir(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = memName(name)
+	if _, ok := m.files[name]; ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if err := m.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	m.files[name] = &memFile{name: name, mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (m *MemFS) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[memName(name)]
+	if !ok {
+		return &fs.PathError{Op: "chmod", Path: name, Err: fs.ErrNotExist}
+	}
+	f.mode = f.mode.Type() | mode.Perm()
+	return nil
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = memName(name)
+	f, ok := m.files[name]
+	switch {
+	case ok && !f.mode.IsRegular():
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		if err := m.checkParent("open", name); err != nil {
+			return nil, err
+		}
+		f = &memFile{name: name, mode: perm.Perm()}
+		m.files[name] = f
+	}
+	if flag&os.O_TRUNC != 0 {
+		f.data = nil
+	}
+	f.modTime = time.Now()
+	return &memWriter{fs: m, file: f}, nil
+}
+
+func (m *MemFS) Symlink(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name := memName(newname)
+	if _, ok := m.files[name]; ok {
+		return &fs.PathError{Op: "symlink", Path: name, Err: fs.ErrExist}
+	}
+	if err := m.checkParent("symlink", name); err != nil {
+		return err
+	}
+	m.files[name] = &memFile{name: name, mode: fs.ModeSymlink | 0777, modTime: time.Now(), data: []byte(oldname)}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = memName(name)
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	prefix := name + "/"
+	for other := range m.files {
+		if strings.HasPrefix(other, prefix) {
+			return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemFS) Lchown(name string, uid, gid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[memName(name)]
+	if !ok {
+		return &fs.PathError{Op: "lchown", Path: name, Err: fs.ErrNotExist}
+	}
+	f.uid, f.gid = uid, gid
+	return nil
+}
+
+func (m *MemFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[memName(name)]
+	if !ok {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrNotExist}
+	}
+	if !mtime.IsZero() {
+		f.modTime = mtime
+	}
+	return nil
+}
+
+// Link adds newname for the file of oldname, they share the content.
+func (m *MemFS) Link(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[memName(oldname)]
+	if !ok {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	if !f.mode.IsRegular() {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrInvalid}
+	}
+	name := memName(newname)
+	if _, ok = m.files[name]; ok {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	if err := m.checkParent("link", name); err != nil {
+		return err
+	}
+	link := *f
+	link.name = name
+	m.files[name] = &link
+	return nil
+}
+
+func (m *MemFS) Lsetxattr(name, attr string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[memName(name)]
+	if !ok {
+		return &fs.PathError{Op: "setxattr", Path: name, Err: fs.ErrNotExist}
+	}
+	if f.xattrs == nil {
+		f.xattrs = map[string][]byte{}
+	}
+	f.xattrs[attr] = bytes.Clone(value)
+	return nil
+}
+
+func (m *MemFS) checkParent(op, name string) error {
+	if parent, ok := m.files[path.Dir(name)]; !ok || !parent.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+// Open implements fs.FS, symbolic links are not followed.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	info := *f
+	info.data = bytes.Clone(f.data)
+
+	if !f.mode.IsDir() {
+		return &memReader{info: &info, Reader: bytes.NewReader(info.data)}, nil
+	}
+
+	var entries []fs.DirEntry
+	for other, child := range m.files {
+		if other != "." && path.Dir(other) == name {
+			childInfo := *child
+			entries = append(entries, fs.FileInfoToDirEntry(&childInfo))
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return &memDir{info: &info, entries: entries}, nil
+}
+
+func (f *memFile) Name() string       { return path.Base(f.name) }
+func (f *memFile) Size() int64        { return int64(len(f.data)) }
+func (f *memFile) Mode() fs.FileMode  { return f.mode }
+func (f *memFile) ModTime() time.Time { return f.modTime }
+func (f *memFile) IsDir() bool        { return f.mode.IsDir() }
+func (f *memFile) Sys() any           { return nil }
+
+type memWriter struct {
+	fs   *MemFS
+	file *memFile
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.fs.mu.Lock()
+	defer w.fs.mu.Unlock()
+	w.file.data = append(w.file.data, p...)
+	return len(p), nil
+}
+
+func (w *memWriter) Close() error {
+	return nil
+}
+
+type memReader struct {
+	*bytes.Reader
+	info *memFile
+}
+
+func (r *memReader) Stat() (fs.FileInfo, error) { return r.info, nil }
+func (r *memReader) Close() error               { return nil }
+
+type memDir struct {
+	info    *memFile
+	entries []fs.DirEntry
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+var _ fs.ReadDirFile = (*memDir)(nil)
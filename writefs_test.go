@@ -0,0 +1,91 @@
+package pzip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExtractFrom_MemFS(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := ArchiveTo(context.Background(), buf, &ArchiveOptions{
+		Concurrency: 2,
+		Level:       -1,
+		Entries: []Entry{
+			{Name: "a.txt", Size: 5, Mode: 0600, Open: openString("hello")},
+			{Name: "dir/", Mode: os.ModeDir | 0750},
+			{Name: "dir/b.txt", Size: 1200, Mode: 0644, Open: openString(strings.Repeat("hello world\n", 100))},
+			{Name: "dir/link", Size: 5, Mode: os.ModeSymlink | 0777, Open: openString("b.txt")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stream := range []bool{false, true} {
+		mfs := NewMemFS()
+		opts := &ExtractOptions{OutDir: "out", Concurrency: 2, FS: mfs}
+		if stream {
+			err = ExtractStream(context.Background(), bytes.NewReader(buf.Bytes()), opts)
+		} else {
+			err = ExtractFrom(context.Background(), bytes.NewReader(buf.Bytes()), int64(buf.Len()), opts)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = fstest.TestFS(mfs, "out/a.txt", "out/dir/b.txt", "out/dir/link"); err != nil {
+			t.Error(err)
+		}
+		b, _ := fs.ReadFile(mfs, "out/dir/b.txt")
+		if string(b) != strings.Repeat("hello world\n", 100) {
+			t.Errorf("out/dir/b.txt: got %q", b)
+		}
+		info, err := fs.Stat(mfs, "out/a.txt")
+		if err != nil || info.Mode() != 0600 {
+			t.Errorf("out/a.txt: got %v, %v, want mode %v", info, err, fs.FileMode(0600))
+		}
+		info, err = fs.Stat(mfs, "out/dir/link")
+		if err != nil || !IsSymlink(info.Mode()) {
+			t.Errorf("out/dir/link: got %v, %v, want symlink", info, err)
+		}
+	}
+}
+
+func TestRootFS(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	rfs, err := NewRootFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = rfs.Symlink(outside, "escape"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"../a.txt", "/a.txt", "escape/a.txt"} {
+		if _, err = rfs.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0644); !errors.Is(err, ErrInsecurePath) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInsecurePath)
+		}
+	}
+
+	// a link at the file name is replaced rather than written through
+	if err = rfs.Symlink(filepath.Join(outside, "b.txt"), "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := rfs.OpenFile("b.txt", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte("hello"))
+	_ = w.Close()
+	if _, err = os.Stat(filepath.Join(outside, "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("b.txt written outside of the root: %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"bufio"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"strings"
 	"unicode/utf8"
@@ -19,6 +20,8 @@ const (
 	directory64LocSignature  = 0x07064b50
 	directory64EndSignature  = 0x06064b50
 	dataDescriptorSignature  = 0x08074b50 // de-facto standard; required by OS X Finder
+	spanningSignature        = 0x08074b50 // first 4 bytes of a split archive
+	spanningMarker           = 0x30304b50 // split archive that fits in a single segment
 	fileHeaderLen            = 30         // + filename + extra
 	directoryHeaderLen       = 46         // + filename + extra + comment
 	directoryEndLen          = 22         // + comment
@@ -26,6 +29,7 @@ const (
 	dataDescriptor64Len      = 24         // two uint32: signature, crc32 | two uint64: compressed size, size
 	directory64LocLen        = 20         //
 	directory64EndLen        = 56         // + extra
+	zip64ExtraLen            = 28         // two uint16: id, size | three uint64: sizes, offset
 
 	// Constants for the first byte in CreatorVersion.
 	creatorFAT    = 0
@@ -37,6 +41,8 @@ const (
 	// Version numbers.
 	zipVersion20 = 20
 	zipVersion45 = 45
+	zipVersion51 = 51 // AES encryption
+	zipVersion63 = 63 // Zstandard
 
 	// Limits for non zip64 files.
 	uint16max = (1 << 16) - 1
@@ -51,23 +57,38 @@ const (
 	// have been invented. Pervasive use effectively makes them "official".
 	//
 	// See http://mdfs.net/Docs/Comp/Archiving/Zip/ExtraField
-	zip64ExtraID       = 0x0001 // Zip64 extended information
-	ntfsExtraID        = 0x000a // NTFS
-	unixExtraID        = 0x000d // UNIX
-	extTimeExtraID     = 0x5455 // Extended timestamp
-	infoZipUnixExtraID = 0x5855 // Info-ZIP Unix extension
+	zip64ExtraID          = 0x0001 // Zip64 extended information
+	ntfsExtraID           = 0x000a // NTFS
+	unixExtraID           = 0x000d // UNIX
+	extTimeExtraID        = 0x5455 // Extended timestamp
+	infoZipUnixExtraID    = 0x5855 // Info-ZIP Unix extension
+	unicodeCommentExtraID = 0x6375 // Info-ZIP Unicode Comment
+	unicodePathExtraID    = 0x7075 // Info-ZIP Unicode Path
+	infoZipNewUnixExtraID = 0x7875 // Info-ZIP new Unix
 )
 
 type header struct {
 	*FileHeader
+	// disk is the segment of the local header in a split archive,
+	// offset is relative to the start of it.
+	disk               uint32
 	offset             uint64
 	offset32           uint32
 	compressedSize32   uint32
 	uncompressedSize32 uint32
+	// zip64 forces the zip64 sizes, e.g. when the local header has them.
+	zip64 bool
+	// reserved reports whether the crc32 and sizes are written in place later.
+	reserved bool
 }
 
 func (h *header) isZip64() bool {
-	return h.CompressedSize64 >= uint32max || h.UncompressedSize64 >= uint32max
+	return h.zip64 || h.CompressedSize64 >= uint32max || h.UncompressedSize64 >= uint32max
+}
+
+// hasDataDescriptor reports whether the crc32 and sizes follow the file data.
+func (h *header) hasDataDescriptor() bool {
+	return h.Flags&0x8 != 0
 }
 
 func (h *header) prepare() {
@@ -82,7 +103,7 @@ func headerPrepareByAppend(h *header) {
 
 	if h.isZip64() || h.offset >= uint32max {
 
-		h.ReaderVersion = zipVersion45
+		h.ReaderVersion = max(h.ReaderVersion, zipVersion45)
 
 		// 3x uint64
 		zip64bufData := make([]byte, 0, 24)
@@ -114,9 +135,9 @@ func headerPrepareByWriteBuf(h *header) {
 
 	if h.isZip64() || h.offset >= uint32max {
 
-		h.ReaderVersion = zipVersion45
+		h.ReaderVersion = max(h.ReaderVersion, zipVersion45)
 
-		var zip64buf [28]byte // 2x uint16 + 3x uint64
+		var zip64buf [zip64ExtraLen]byte // 2x uint16 + 3x uint64
 		eb := writeBuf(zip64buf[:])
 		eb.uint16(zip64ExtraID)
 
@@ -146,6 +167,13 @@ func headerPrepareByWriteBuf(h *header) {
 type Writer struct {
 	cw  *countWriter
 	dir []*header
+	// last is the header whose data descriptor has not been written yet.
+	last *header
+	// at and seeker write the output of NewWriterAt, open is the region
+	// whose end is unknown until it is closed.
+	at     io.WriterAt
+	seeker io.Seeker
+	open   *Region
 
 	closed bool
 
@@ -157,6 +185,44 @@ func NewWriter(w io.Writer) *Writer {
 	return &Writer{cw: &countWriter{w: bufio.NewWriter(w)}}
 }
 
+// NewWriterAt returns a new [Writer] writing a zip file to w from offset 0, the
+// data of its files can be written by other goroutines with [Writer.CreateAt].
+func NewWriterAt(w io.WriterAt) *Writer {
+	ow := io.NewOffsetWriter(w, 0)
+	return &Writer{cw: &countWriter{w: bufio.NewWriter(ow)}, at: w, seeker: ow}
+}
+
+// MinSplitSize is the minimum segment size of a split archive.
+const MinSplitSize = 64 << 10
+
+// NewSplitWriter returns a new [Writer] writing a split zip file to segments of
+// at most size bytes. next returns the writer of the segment disk, starting
+// at 0, the previous segment is complete when it is called. Headers are not
+// split across segments.
+func NewSplitWriter(size int64, next func(disk uint32) (io.Writer, error)) (*Writer, error) {
+	if size < MinSplitSize {
+		return nil, fmt.Errorf("zip: split size %d is less than %d", size, MinSplitSize)
+	}
+	first, err := next(0)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{cw: &countWriter{w: bufio.NewWriter(first), size: uint64(size), next: next}}
+
+	// 8.5.3 the first segment starts with the spanning signature
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], spanningSignature)
+	if _, err = w.cw.Write(buf[:]); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Disks returns the number of segments written.
+func (w *Writer) Disks() uint32 {
+	return w.cw.disk + 1
+}
+
 // Flush flushes any buffered data to the underlying writer.
 // Calling Flush is not normally necessary; calling Close is sufficient.
 func (w *Writer) Flush() error {
@@ -180,6 +246,42 @@ func (w *Writer) prepare(fh *FileHeader) error {
 		// See https://golang.org/issue/11144 confusion.
 		return errors.New("archive/zip: invalid duplicate FileHeader")
 	}
+	if err := w.closeRegion(); err != nil {
+		return err
+	}
+	return w.closeLast()
+}
+
+// closeLast writes the data descriptor of the last file if it has one.
+func (w *Writer) closeLast() error {
+	if w.last == nil {
+		return nil
+	}
+	h := w.last
+	w.last = nil
+
+	h.CompressedSize = uint32(min(h.CompressedSize64, uint32max))
+	h.UncompressedSize = uint32(min(h.UncompressedSize64, uint32max))
+
+	// reference: https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT
+	// 4.3.9  Data descriptor:
+	// the local header has the zip64 extra field, so the sizes are 8 bytes.
+	if err := w.cw.reserve(dataDescriptor64Len); err != nil {
+		return err
+	}
+	var buf [dataDescriptor64Len]byte
+	b := writeBuf(buf[:])
+	b.uint32(dataDescriptorSignature)
+	b.uint32(h.CRC32)
+	b.uint64(h.CompressedSize64)
+	b.uint64(h.UncompressedSize64)
+	if _, err := w.cw.Write(buf[:]); err != nil {
+		return err
+	}
+
+	// sizes are known now, prepare the central directory header
+	// in zip64 format like the local header.
+	h.prepare()
 	return nil
 }
 
@@ -192,7 +294,44 @@ func writeHeader(w io.Writer, h *header) error {
 	}
 	// reference: https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT
 	// 4.3.7  Local file header:
-	h.prepare()
+	crc32 := h.CRC32
+	extra := h.Extra
+	switch {
+	case h.hasDataDescriptor():
+		// 4.4.9 crc32 and sizes are zero, they are in the data descriptor.
+		// 4.5.3 sizes are 0xFFFFFFFF with zip64 sizes in the extra field,
+		// since the size may exceed 4GB.
+		h.zip64 = true
+		fallthrough
+	case h.reserved:
+		// crc32 and sizes are zero until they are written in place
+		crc32 = 0
+		h.compressedSize32 = 0
+		h.uncompressedSize32 = 0
+		if !h.zip64 {
+			break
+		}
+		h.ReaderVersion = max(h.ReaderVersion, zipVersion45)
+		h.compressedSize32 = uint32max
+		h.uncompressedSize32 = uint32max
+
+		var zip64buf [20]byte // 2x uint16 + 2x uint64
+		eb := writeBuf(zip64buf[:])
+		eb.uint16(zip64ExtraID)
+		eb.uint16(16) // size = 2x uint64
+		eb.uint64(0)
+		eb.uint64(0)
+		extra = append(extra[:len(extra):len(extra)], zip64buf[:]...)
+		if len(extra) > uint16max {
+			return errors.New("zip: header extra too long")
+		}
+	default:
+		h.prepare()
+		extra = h.Extra
+		if len(extra) > uint16max {
+			return errors.New("zip: header extra too long")
+		}
+	}
 
 	var buf [fileHeaderLen]byte
 	b := writeBuf(buf[:])
@@ -202,18 +341,18 @@ func writeHeader(w io.Writer, h *header) error {
 	b.uint16(h.Method)
 	b.uint16(h.ModifiedTime)
 	b.uint16(h.ModifiedDate)
-	b.uint32(h.CRC32)
+	b.uint32(crc32)
 	b.uint32(h.compressedSize32)
 	b.uint32(h.uncompressedSize32)
 	b.uint16(uint16(len(h.Name)))
-	b.uint16(uint16(len(h.Extra)))
+	b.uint16(uint16(len(extra)))
 	if _, err := w.Write(buf[:]); err != nil {
 		return err
 	}
 	if _, err := io.WriteString(w, h.Name); err != nil {
 		return err
 	}
-	_, err := w.Write(h.Extra)
+	_, err := w.Write(extra)
 	return err
 }
 
@@ -223,6 +362,10 @@ func writeHeader(w io.Writer, h *header) error {
 // [Writer.CreateHeader], [Writer.CreateRaw], or [Writer.Close].
 //
 // In contrast to [Writer.CreateHeader], the bytes passed to Writer are not compressed.
+//
+// If fh.Flags has the data descriptor bit (0x8) set, the CRC32 and sizes of fh
+// may be set after the contents are written, they are written in a zip64 data
+// descriptor on the next call.
 func (w *Writer) CreateRaw(fh *FileHeader) (io.Writer, error) {
 	if err := w.prepare(fh); err != nil {
 		return nil, err
@@ -231,14 +374,23 @@ func (w *Writer) CreateRaw(fh *FileHeader) (io.Writer, error) {
 	fh.CompressedSize = uint32(min(fh.CompressedSize64, uint32max))
 	fh.UncompressedSize = uint32(min(fh.UncompressedSize64, uint32max))
 
+	// the local header is not split
+	if err := w.cw.reserve(uint64(fileHeaderLen + len(fh.Name) + len(fh.Extra) + zip64ExtraLen)); err != nil {
+		return nil, err
+	}
+
 	h := &header{
 		FileHeader: fh,
+		disk:       w.cw.disk,
 		offset:     w.cw.count,
 	}
 	w.dir = append(w.dir, h)
 	if err := writeHeader(w.cw, h); err != nil {
 		return nil, err
 	}
+	if h.hasDataDescriptor() {
+		w.last = h
+	}
 
 	if strings.HasSuffix(fh.Name, "/") {
 		return dirWriter{}, nil
@@ -247,18 +399,190 @@ func (w *Writer) CreateRaw(fh *FileHeader) (io.Writer, error) {
 	return w.cw, nil
 }
 
+// CreateAt adds a file to the zip archive like [Writer.CreateRaw], but its data
+// is written with the returned [Region], which may be used by another goroutine.
+// If size is not negative, the data is size bytes and the writer can be used
+// for the next files right away. Otherwise the data ends where the last write
+// to the region does, and the writer can not be used until the region is closed.
+// The writer must be created by [NewWriterAt].
+func (w *Writer) CreateAt(fh *FileHeader, size int64) (*Region, error) {
+	if w.at == nil {
+		return nil, errors.New("zip: CreateAt of a writer not created by NewWriterAt")
+	}
+	if err := w.prepare(fh); err != nil {
+		return nil, err
+	}
+
+	h := &header{
+		FileHeader: fh,
+		offset:     w.cw.count,
+		reserved:   true,
+	}
+	// the local header has the zip64 sizes if they may exceed 4GB, leaving room
+	// for the expansion of incompressible data in an open region
+	if size < 0 {
+		h.zip64 = fh.UncompressedSize64 >= uint32max-uint32max/16
+	} else {
+		h.zip64 = uint64(size) >= uint32max || fh.UncompressedSize64 >= uint32max
+	}
+	w.dir = append(w.dir, h)
+	if err := writeHeader(w.cw, h); err != nil {
+		return nil, err
+	}
+	if err := w.cw.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	r := &Region{
+		w:       w.at,
+		h:       h,
+		start:   int64(w.cw.count),
+		size:    size,
+		zip64At: int64(h.offset) + fileHeaderLen + int64(len(fh.Name)+len(fh.Extra)),
+	}
+	if size < 0 {
+		w.open = r
+		return r, nil
+	}
+	return r, w.skip(uint64(size))
+}
+
+// closeRegion moves past the data of the open region, which must be closed.
+func (w *Writer) closeRegion() error {
+	if w.open == nil {
+		return nil
+	}
+	if !w.open.closed {
+		return errors.New("zip: region is not closed")
+	}
+	end := w.open.end
+	w.open = nil
+	return w.skip(uint64(end))
+}
+
+// skip moves the output of NewWriterAt n bytes forward.
+func (w *Writer) skip(n uint64) error {
+	if _, err := w.seeker.Seek(int64(n), io.SeekCurrent); err != nil {
+		return err
+	}
+	w.cw.count += n
+	w.cw.total += n
+	return nil
+}
+
+// Region is the data of a file created by [Writer.CreateAt]. It is not safe
+// for concurrent use.
+type Region struct {
+	w     io.WriterAt
+	h     *header
+	start int64
+	// size is -1 if the region is open, end is where its data ends.
+	size int64
+	end  int64
+	// zip64At is the offset of the zip64 extra field in the local header.
+	zip64At int64
+	closed  bool
+}
+
+// WriteAt writes p at offset off of the data.
+func (r *Region) WriteAt(p []byte, off int64) (int, error) {
+	if r.closed {
+		return 0, errors.New("zip: write to closed region")
+	}
+	if off < 0 || r.size >= 0 && off+int64(len(p)) > r.size {
+		return 0, fmt.Errorf("zip: write of %d bytes at %d out of region", len(p), off)
+	}
+	n, err := r.w.WriteAt(p, r.start+off)
+	r.end = max(r.end, off+int64(n))
+	return n, err
+}
+
+// Close writes the CRC32 and sizes of the file header in the local header, they
+// must be set before. The compressed size is the size of the region, or where
+// the data ends if it is open.
+func (r *Region) Close() error {
+	if r.closed {
+		return errors.New("zip: region closed twice")
+	}
+	h := r.h
+	size := r.size
+	if size < 0 {
+		size = r.end
+	}
+	if h.CompressedSize64 != uint64(size) {
+		return fmt.Errorf("zip: compressed size %d of region of %d bytes", h.CompressedSize64, size)
+	}
+	if !h.zip64 && (h.CompressedSize64 >= uint32max || h.UncompressedSize64 >= uint32max) {
+		return errors.New("zip: region exceeds 4GB without zip64 sizes")
+	}
+	h.CompressedSize = uint32(min(h.CompressedSize64, uint32max))
+	h.UncompressedSize = uint32(min(h.UncompressedSize64, uint32max))
+	h.prepare()
+
+	// 4.3.7 crc32, compressed size and uncompressed size of the local header
+	var buf [12]byte
+	b := writeBuf(buf[:])
+	b.uint32(h.CRC32)
+	b.uint32(h.compressedSize32)
+	b.uint32(h.uncompressedSize32)
+	if _, err := r.w.WriteAt(buf[:], int64(h.offset)+14); err != nil {
+		return err
+	}
+	if h.zip64 {
+		var buf [16]byte
+		b := writeBuf(buf[:])
+		b.uint64(h.UncompressedSize64)
+		b.uint64(h.CompressedSize64)
+		if _, err := r.w.WriteAt(buf[:], r.zip64At+4); err != nil {
+			return err
+		}
+	}
+	r.closed = true
+	return nil
+}
+
 func (w *Writer) Close() error {
 	if w.closed {
 		return errors.New("zip: writer closed twice")
 	}
 	w.closed = true
 
+	if err := w.closeRegion(); err != nil {
+		return err
+	}
+	if err := w.closeLast(); err != nil {
+		return err
+	}
+
 	// write central directory
-	start := w.cw.count
+	if err := w.cw.reserve(directoryHeaderLen); err != nil {
+		return err
+	}
+	start, startDisk, startTotal := w.cw.count, w.cw.disk, w.cw.total
+	// records on the disk of the end record
+	var diskRecords uint64
 
 	// reference: https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT
 	// 4.3.12  Central directory structure:
 	for _, h := range w.dir {
+		if err := w.cw.reserve(uint64(directoryHeaderLen + len(h.Name) + len(h.Extra) + len(h.Comment))); err != nil {
+			return err
+		}
+		if h.disk >= uint16max || w.cw.disk >= uint16max {
+			return errors.New("zip: too many segments")
+		}
+		// the zip64 extra block may be added after the local header is written
+		if len(h.Extra) > uint16max {
+			return fmt.Errorf("zip: header extra of %q too long", h.Name)
+		}
+		if len(h.Comment) > uint16max {
+			return fmt.Errorf("zip: header comment of %q too long", h.Name)
+		}
+		if w.cw.count == 0 {
+			diskRecords = 0
+		}
+		diskRecords++
+
 		var buf [directoryHeaderLen]byte
 		b := writeBuf(buf[:])
 		b.uint32(uint32(directoryHeaderSignature))
@@ -275,7 +599,8 @@ func (w *Writer) Close() error {
 		b.uint16(uint16(len(h.Extra))) // Includes the Zip64 extra block if present
 		b.uint16(uint16(len(h.Comment)))
 
-		b = b[4:] // skip disk number start and internal file attr (2x uint16)
+		b.uint16(uint16(h.disk)) // disk number start
+		b = b[2:]                // skip internal file attr (uint16)
 		b.uint32(h.ExternalAttrs)
 		b.uint32(h.offset32)
 
@@ -293,13 +618,19 @@ func (w *Writer) Close() error {
 		}
 
 	}
-	end := w.cw.count
-
 	records := uint64(len(w.dir))
-	size := uint64(end - start)
-	offset := uint64(start)
+	size := w.cw.total - startTotal
+	offset := start
 
 	if records >= uint16max || size >= uint32max || offset >= uint32max {
+		if err := w.cw.reserve(directory64EndLen + directory64LocLen + directoryEndLen + uint64(len(w.comment))); err != nil {
+			return err
+		}
+		if w.cw.count == 0 {
+			diskRecords = 0
+		}
+		end := w.cw.count
+
 		var buf [directory64EndLen + directory64LocLen]byte
 		b := writeBuf(buf[:])
 
@@ -309,18 +640,18 @@ func (w *Writer) Close() error {
 		b.uint64(directory64EndLen - 12) // length minus signature (uint32) and length fields (uint64)
 		b.uint16(zipVersion45)           // version made by
 		b.uint16(zipVersion45)           // version needed to extract
-		b.uint32(0)                      // number of this disk
-		b.uint32(0)                      // number of the disk with the start of the central directory
-		b.uint64(records)                // total number of entries in the central directory on this disk
+		b.uint32(w.cw.disk)              // number of this disk
+		b.uint32(startDisk)              // number of the disk with the start of the central directory
+		b.uint64(diskRecords)            // total number of entries in the central directory on this disk
 		b.uint64(records)                // total number of entries in the central directory
 		b.uint64(size)                   // size of the central directory
 		b.uint64(offset)                 // offset of start of central directory with respect to the starting disk number
 
 		// 4.3.15 Zip64 end of central directory locator
 		b.uint32(directory64LocSignature)
-		b.uint32(0)           // number of the disk with the start of the zip64 end of central directory
-		b.uint64(uint64(end)) // relative offset of the zip64 end of central directory record
-		b.uint32(1)           // total number of disks
+		b.uint32(w.cw.disk)     // number of the disk with the start of the zip64 end of central directory
+		b.uint64(end)           // relative offset of the zip64 end of central directory record
+		b.uint32(w.cw.disk + 1) // total number of disks
 
 		if _, err := w.cw.Write(buf[:]); err != nil {
 			return err
@@ -329,16 +660,25 @@ func (w *Writer) Close() error {
 		// store max values in the regular end record to signal
 		// that the zip64 values should be used instead
 		records = uint16max
+		diskRecords = uint16max
 		size = uint32max
 		offset = uint32max
+	} else {
+		if err := w.cw.reserve(directoryEndLen + uint64(len(w.comment))); err != nil {
+			return err
+		}
+		if w.cw.count == 0 {
+			diskRecords = 0
+		}
 	}
 
 	// 4.3.16  End of central directory record:
 	var buf [directoryEndLen]byte
 	b := writeBuf(buf[:])
 	b.uint32(uint32(directoryEndSignature))
-	b = b[4:]                        // skip over disk number and first disk number (2x uint16)
-	b.uint16(uint16(records))        // number of entries this disk
+	b.uint16(uint16(w.cw.disk))      // number of this disk
+	b.uint16(uint16(startDisk))      // number of the disk with the start of the central directory
+	b.uint16(uint16(diskRecords))    // number of entries this disk
 	b.uint16(uint16(records))        // number of entries total
 	b.uint32(uint32(size))           // size of directory
 	b.uint32(uint32(offset))         // start of directory
@@ -361,15 +701,68 @@ func (dirWriter) Write(b []byte) (int, error) {
 	return 0, errors.New("zip: write to directory")
 }
 
+// countWriter counts the bytes written, if size is not 0 it writes a split
+// archive, count is the offset in the current segment disk.
 type countWriter struct {
 	w     *bufio.Writer
 	count uint64
+	total uint64
+
+	size uint64
+	disk uint32
+	next func(disk uint32) (io.Writer, error)
 }
 
 func (w *countWriter) Write(p []byte) (int, error) {
-	n, err := w.w.Write(p)
-	w.count += uint64(n)
-	return n, err
+	if w.size == 0 {
+		n, err := w.w.Write(p)
+		w.count += uint64(n)
+		w.total += uint64(n)
+		return n, err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if w.count == w.size {
+			if err := w.nextDisk(); err != nil {
+				return written, err
+			}
+		}
+		n, err := w.w.Write(p[:min(uint64(len(p)), w.size-w.count)])
+		written += n
+		w.count += uint64(n)
+		w.total += uint64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// reserve starts a new segment if the record of n bytes does not fit in the current one.
+func (w *countWriter) reserve(n uint64) error {
+	if w.size == 0 || w.count+n <= w.size {
+		return nil
+	}
+	if n > w.size {
+		return fmt.Errorf("zip: record of %d bytes does not fit in a segment", n)
+	}
+	return w.nextDisk()
+}
+
+func (w *countWriter) nextDisk() error {
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	next, err := w.next(w.disk + 1)
+	if err != nil {
+		return err
+	}
+	w.w.Reset(next)
+	w.disk++
+	w.count = 0
+	return nil
 }
 
 // detectUTF8 reports whether s is a valid UTF-8 string, and whether the string
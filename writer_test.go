@@ -1,7 +1,16 @@
 package pzip
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -57,3 +66,205 @@ func BenchmarkHeaderPrepare(b *testing.B) {
 		}
 	})
 }
+
+func TestSplitWriter(t *testing.T) {
+	var segments []*bytes.Buffer
+	w, err := NewSplitWriter(MinSplitSize, func(disk uint32) (io.Writer, error) {
+		if int(disk) != len(segments) {
+			t.Fatalf("got disk %d, want %d", disk, len(segments))
+		}
+		segments = append(segments, new(bytes.Buffer))
+		return segments[disk], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string][]byte)
+	for i := 0; i < 10; i++ {
+		name := "file" + strconv.Itoa(i)
+		data := make([]byte, rand.Intn(MinSplitSize))
+		rand.Read(data)
+		files[name] = data
+		fw, err := w.CreateRaw(&FileHeader{
+			Name:               name,
+			CRC32:              crc32.ChecksumIEEE(data),
+			CompressedSize64:   uint64(len(data)),
+			UncompressedSize64: uint64(len(data)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = fw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if int(w.Disks()) != len(segments) || len(segments) < 2 {
+		t.Fatalf("got %d disks, %d segments", w.Disks(), len(segments))
+	}
+	var all []byte
+	starts := make([]int, len(segments))
+	for i, seg := range segments {
+		if seg.Len() > MinSplitSize {
+			t.Errorf("segment %d: %d bytes exceeds %d", i, seg.Len(), MinSplitSize)
+		}
+		starts[i] = len(all)
+		all = append(all, seg.Bytes()...)
+	}
+	if binary.LittleEndian.Uint32(all) != spanningSignature {
+		t.Errorf("missing spanning signature")
+	}
+
+	last := segments[len(segments)-1].Bytes()
+	end := readBuf(last[len(last)-directoryEndLen+4:])
+	disk, dirDisk := end.uint16(), end.uint16()
+	end.uint16() // entries on this disk
+	records, size, offset := end.uint16(), end.uint32(), end.uint32()
+	if int(disk) != len(segments)-1 || int(records) != len(files) {
+		t.Fatalf("got disk %d, %d records", disk, records)
+	}
+
+	dir := bytes.NewReader(all[starts[dirDisk]+int(offset) : starts[dirDisk]+int(offset)+int(size)])
+	for i := 0; i < len(files); i++ {
+		var sig [4]byte
+		_, _ = io.ReadFull(dir, sig[:])
+		r, err := readDirectoryHeader(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		local := all[starts[r.disk]+int(r.offset):]
+		if binary.LittleEndian.Uint32(local) != fileHeaderSignature {
+			t.Fatalf("%s: no local file header at disk %d offset %d", r.file.Name, r.disk, r.offset)
+		}
+		start := fileHeaderLen + len(r.file.Name) + int(binary.LittleEndian.Uint16(local[28:]))
+		data := local[start : start+int(r.file.CompressedSize64)]
+		if !bytes.Equal(data, files[r.file.Name]) {
+			t.Errorf("%s: data mismatch", r.file.Name)
+		}
+	}
+}
+
+func TestWriterAt(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stored := bytes.Repeat([]byte("stored"), 1000)
+	open := bytes.Repeat([]byte("open"), 1000)
+	w := NewWriterAt(f)
+	fixed, err := w.CreateAt(&FileHeader{Name: "fixed", UncompressedSize64: uint64(len(stored))}, int64(len(stored)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the next file is written before the data of the fixed region
+	fw, err := w.CreateRaw(&FileHeader{Name: "raw", CRC32: crc32.ChecksumIEEE([]byte("raw")), CompressedSize64: 3, UncompressedSize64: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fw.Write([]byte("raw")); err != nil {
+		t.Fatal(err)
+	}
+	region, err := w.CreateAt(&FileHeader{Name: "open", UncompressedSize64: uint64(len(open))}, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.CreateRaw(&FileHeader{Name: "early"}); err == nil {
+		t.Error("got no error of a file created before the open region is closed")
+	}
+
+	if _, err = fixed.WriteAt(stored[1:], 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fixed.WriteAt(stored[:2], int64(len(stored)-1)); err == nil {
+		t.Error("got no error of a write out of the region")
+	}
+	if _, err = fixed.WriteAt(stored[:1], 0); err != nil {
+		t.Fatal(err)
+	}
+	fixed.h.CRC32 = crc32.ChecksumIEEE(stored)
+	fixed.h.CompressedSize64 = uint64(len(stored))
+	if err = fixed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.Copy(io.NewOffsetWriter(region, 0), bytes.NewReader(open)); err != nil {
+		t.Fatal(err)
+	}
+	region.h.CRC32 = crc32.ChecksumIEEE(open)
+	region.h.CompressedSize64 = uint64(len(open))
+	if err = region.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]byte{"fixed": stored, "raw": []byte("raw"), "open": open}
+	if len(r.File) != len(want) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(want))
+	}
+	for _, zf := range r.File {
+		rc, err := zf.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil || !bytes.Equal(got, want[zf.Name]) {
+			t.Errorf("%s: content mismatch, %v", zf.Name, err)
+		}
+	}
+
+	// the local headers have the crc32 and sizes as well
+	mfs := NewMemFS()
+	if err = ExtractStream(context.Background(), bytes.NewReader(data), &ExtractOptions{Concurrency: 1, FS: mfs}); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range want {
+		if !bytes.Equal(mfs.files[name].data, content) {
+			t.Errorf("stream %s: content mismatch", name)
+		}
+	}
+}
+
+func TestWriter_ExtraTooLong(t *testing.T) {
+	w := NewWriter(io.Discard)
+	// the zip64 extra block is added to the central directory header
+	w.dir = append(w.dir, &header{FileHeader: &FileHeader{Name: "long", Extra: make([]byte, uint16max+1)}})
+	if err := w.Close(); err == nil {
+		t.Error("got no error of an extra field longer than 65535 bytes")
+	}
+}
+
+func TestWriter_LocalZip64(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	// the sizes are known, the local header has them in the zip64 extra field
+	if _, err := w.CreateRaw(&FileHeader{Name: "large", CompressedSize64: uint32max + 1, UncompressedSize64: uint32max + 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	local := buf.Bytes()
+	extra := local[fileHeaderLen+len("large"):]
+	if n := int(binary.LittleEndian.Uint16(local[28:])); n != len(extra) || n == 0 {
+		t.Fatalf("got extra length %d, want %d", n, len(extra))
+	}
+	if id := binary.LittleEndian.Uint16(extra); id != zip64ExtraID {
+		t.Errorf("got extra field %#x, want %#x", id, zip64ExtraID)
+	}
+}
@@ -0,0 +1,92 @@
+package pzip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// xattrExtraID is the extra field of pzip with the extended attributes of a
+// file, POSIX ACLs are the system.posix_acl_* attributes on Linux. The field is:
+//
+//	version     uint8, 1
+//	attributes  repeated until the end of the field:
+//	  name length   uint8
+//	  name          the attribute name, such as "user.comment"
+//	  value length  uint16
+//	  value
+const xattrExtraID = 0x7870
+
+// Xattr is an extended attribute.
+type Xattr struct {
+	Name  string
+	Value []byte
+}
+
+// xattrExtra returns the extra field of attrs of at most limit bytes, or nil
+// if there are none. The attributes that do not fit are left out.
+func xattrExtra(attrs []Xattr, limit int) ([]byte, error) {
+	size := 1
+	fit := make([]Xattr, 0, len(attrs))
+	for _, attr := range attrs {
+		if len(attr.Name) > 255 {
+			return nil, fmt.Errorf("extended attribute %q: name is too long", attr.Name)
+		}
+		n := 3 + len(attr.Name) + len(attr.Value)
+		if 4+size+n > limit {
+			continue
+		}
+		size += n
+		fit = append(fit, attr)
+	}
+	if len(fit) == 0 {
+		return nil, nil
+	}
+
+	extra := make([]byte, 0, 4+size)
+	extra = binary.LittleEndian.AppendUint16(extra, xattrExtraID)
+	extra = binary.LittleEndian.AppendUint16(extra, uint16(size))
+	extra = append(extra, 1) // version
+	for _, attr := range fit {
+		extra = append(extra, byte(len(attr.Name)))
+		extra = append(extra, attr.Name...)
+		extra = binary.LittleEndian.AppendUint16(extra, uint16(len(attr.Value)))
+		extra = append(extra, attr.Value...)
+	}
+	return extra, nil
+}
+
+// FileXattrs returns the extended attributes recorded in the extra fields of f.
+func FileXattrs(f *File) ([]Xattr, error) {
+	var attrs []Xattr
+	err := parseExtra(f.Extra, func(id uint16, field readBuf) error {
+		if id != xattrExtraID || len(field) < 1 || field.uint8() != 1 {
+			return nil
+		}
+		for len(field) > 0 {
+			nameLen := int(field.uint8())
+			if len(field) < nameLen+2 {
+				return ErrFormat
+			}
+			name := string(field.sub(nameLen))
+			valueLen := int(field.uint16())
+			if len(field) < valueLen {
+				return ErrFormat
+			}
+			attrs = append(attrs, Xattr{Name: name, Value: field.sub(valueLen)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("extended attributes of %q: %w", f.Name, err)
+	}
+	return attrs, nil
+}
+
+// skipXattr reports whether err of setting an extended attribute is because the
+// namespace is not allowed, such as trusted.* for other users than root, or
+// not supported by the filesystem.
+func skipXattr(err error) bool {
+	return errors.Is(err, fs.ErrPermission) || errors.Is(err, errors.ErrUnsupported)
+}
@@ -0,0 +1,71 @@
+package pzip
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of the file at path, whose info
+// is of the OS filesystem, symbolic links are not followed.
+func readXattrs(path string, info fs.FileInfo) ([]Xattr, error) {
+	if _, ok := info.Sys().(*syscall.Stat_t); !ok {
+		return nil, nil
+	}
+	list, get := unix.Listxattr, unix.Getxattr
+	if IsSymlink(info.Mode()) {
+		list, get = unix.Llistxattr, unix.Lgetxattr
+	}
+
+	names, err := readXattr(func(dest []byte) (int, error) { return list(path, dest) })
+	if err != nil || len(names) == 0 {
+		return nil, ignoreUnsupported(err)
+	}
+	var attrs []Xattr
+	for _, name := range bytes.Split(bytes.TrimSuffix(names, []byte{0}), []byte{0}) {
+		value, err := readXattr(func(dest []byte) (int, error) { return get(path, string(name), dest) })
+		if errors.Is(err, unix.ENODATA) {
+			// removed since listed
+			continue
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "getxattr " + string(name), Path: path, Err: err}
+		}
+		attrs = append(attrs, Xattr{Name: string(name), Value: value})
+	}
+	return attrs, nil
+}
+
+// readXattr calls read with a buffer large enough for the result.
+func readXattr(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := read(buf)
+		if errors.Is(err, unix.ERANGE) {
+			// grown since the size was read
+			continue
+		}
+		return buf[:n], err
+	}
+}
+
+func ignoreUnsupported(err error) error {
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	return err
+}
+
+func lsetxattr(path, attr string, value []byte) error {
+	if err := unix.Lsetxattr(path, attr, value, 0); err != nil {
+		return &fs.PathError{Op: "setxattr " + attr, Path: path, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package pzip
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// readXattrs returns no extended attributes, they are not supported on this system.
+func readXattrs(path string, info fs.FileInfo) ([]Xattr, error) {
+	return nil, nil
+}
+
+func lsetxattr(path, attr string, value []byte) error {
+	return &fs.PathError{Op: "setxattr " + attr, Path: path, Err: errors.ErrUnsupported}
+}
@@ -0,0 +1,113 @@
+package pzip
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestXattrExtra(t *testing.T) {
+	attrs := []Xattr{
+		{Name: "user.comment", Value: []byte("hello")},
+		{Name: "user.empty", Value: []byte{}},
+	}
+	extra, err := xattrExtra(attrs, uint16max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := new(File)
+	f.Extra = extra
+	got, err := FileXattrs(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(attrs) {
+		t.Fatalf("got %d attributes, want %d", len(got), len(attrs))
+	}
+	for i := range attrs {
+		if got[i].Name != attrs[i].Name || !bytes.Equal(got[i].Value, attrs[i].Value) {
+			t.Errorf("got %q=%q, want %q=%q", got[i].Name, got[i].Value, attrs[i].Name, attrs[i].Value)
+		}
+	}
+
+	// the attributes that do not fit are left out
+	large := append([]Xattr{{Name: "user.large", Value: make([]byte, uint16max)}}, attrs...)
+	if got, err := xattrExtra(large, uint16max); err != nil || !bytes.Equal(got, extra) {
+		t.Errorf("got %d bytes, %v, want %d bytes", len(got), err, len(extra))
+	}
+	if got, err := xattrExtra(attrs, len(extra)-1); err != nil || len(got) >= len(extra) {
+		t.Errorf("got %d bytes, %v, want less than %d bytes", len(got), err, len(extra))
+	}
+	if got, err := xattrExtra(attrs, 4); err != nil || got != nil {
+		t.Errorf("got %d bytes, %v, want none", len(got), err)
+	}
+	f.Extra = extra[:len(extra)-1]
+	f.Extra[2]-- // size of the truncated field
+	if _, err = FileXattrs(f); err == nil {
+		t.Error("got no error of a truncated field")
+	}
+}
+
+func TestExtractFrom_Xattrs(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(name, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (OSFS{}).Lsetxattr(name, "user.comment", []byte("greeting")); err != nil {
+		t.Skipf("extended attributes are not supported: %v", err)
+	}
+	// an attribute that does not fit in the extra field is left out, if the
+	// filesystem takes it
+	_ = (OSFS{}).Lsetxattr(name, "user.large", make([]byte, 65400))
+
+	buf := new(bytes.Buffer)
+	err := ArchiveTo(context.Background(), buf, &ArchiveOptions{
+		Concurrency: 2,
+		Files:       []string{name},
+		Xattrs:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stream := range []bool{false, true} {
+		mfs := NewMemFS()
+		opts := &ExtractOptions{Concurrency: 2, FS: mfs, Xattrs: true}
+		if stream {
+			err = ExtractStream(context.Background(), bytes.NewReader(buf.Bytes()), opts)
+		} else {
+			err = ExtractFrom(context.Background(), bytes.NewReader(buf.Bytes()), int64(buf.Len()), opts)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var f *memFile
+		for name, file := range mfs.files {
+			if path.Base(name) == "a.txt" {
+				f = file
+			}
+		}
+		if f == nil {
+			t.Fatal("a.txt is not extracted")
+		}
+		if got := string(f.xattrs["user.comment"]); got != "greeting" {
+			t.Errorf("stream %v: got user.comment %q, want %q", stream, got, "greeting")
+		}
+		if _, ok := f.xattrs["user.large"]; ok {
+			t.Errorf("stream %v: got user.large of %d bytes", stream, len(f.xattrs["user.large"]))
+		}
+	}
+}
+
+func TestExtractOptions_XattrsFS(t *testing.T) {
+	opts := &ExtractOptions{Concurrency: 1, FS: fsOnly{NewMemFS()}, Xattrs: true}
+	if err := opts.prepare(); err == nil {
+		t.Error("got no error of a filesystem without extended attributes")
+	}
+}
+
+// fsOnly hides the optional interfaces of a WriteFS.
+type fsOnly struct{ WriteFS }